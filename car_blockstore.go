@@ -0,0 +1,176 @@
+package graphsplit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+)
+
+// carIndexSuffix is the suffix of the cached block index of a CARv1 file
+const carIndexSuffix = ".idx"
+
+func IsCarIndex(name string) bool {
+	return strings.HasSuffix(name, carIndexSuffix)
+}
+
+// CarBlockstore is a read-only blockstore backed by a CARv1 or CARv2 file. Blocks are read
+// from the file on demand through an index of block offsets, so only the index is kept in memory.
+type CarBlockstore struct {
+	*blockstore.ReadOnly
+	f     *os.File
+	roots []cid.Cid
+}
+
+// OpenCarBlockstore opens a CAR file as a read-only blockstore. The index embedded in a CARv2
+// is used when present, for a CARv1 the index is loaded from the <car>.idx cache or generated
+// with one pass over the file and cached next to it when the directory is writable.
+func OpenCarBlockstore(carPath string) (*CarBlockstore, error) {
+	return openCarBlockstore(carPath, carPath+carIndexSuffix)
+}
+
+// openCarBlockstore opens a CAR file as a read-only blockstore, the index of a CARv1 is cached
+// at indexPath, it is generated every time when indexPath is empty
+func openCarBlockstore(carPath, indexPath string) (*CarBlockstore, error) {
+	f, err := os.Open(carPath)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	opts := []carv2.Option{carv2.ZeroLengthSectionAsEOF(true)}
+	cr, err := carv2.NewReader(f, opts...)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("not a car file: %w", err)
+	}
+
+	var idx index.Index
+	if cr.Version == CarVersion1 && indexPath != "" {
+		idx = loadCarIndex(carPath, indexPath, stat)
+	}
+	bs, err := blockstore.NewReadOnly(f, idx, opts...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if cr.Version == CarVersion1 && idx == nil && indexPath != "" {
+		saveCarIndex(carPath, indexPath, bs.Index())
+	}
+
+	roots, err := bs.Roots()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &CarBlockstore{ReadOnly: bs, f: f, roots: roots}, nil
+}
+
+// Roots returns the root CIDs of the CAR
+func (cb *CarBlockstore) Roots() []cid.Cid {
+	return cb.roots
+}
+
+// DAGService returns an offline dag service reading from the CAR
+func (cb *CarBlockstore) DAGService() ipld.DAGService {
+	return merkledag.NewDAGService(blockservice.New(cb, offline.Exchange(cb)))
+}
+
+func (cb *CarBlockstore) Close() error {
+	cb.ReadOnly.Close() //nolint:errcheck
+	return cb.f.Close()
+}
+
+// loadCarIndex reads the cached index of a CARv1, a cache older than the CAR is ignored
+func loadCarIndex(carPath, indexPath string, carStat os.FileInfo) index.Index {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil || stat.ModTime().Before(carStat.ModTime()) {
+		return nil
+	}
+	idx, err := index.ReadFrom(f)
+	if err != nil {
+		log.Warnf("ignore broken index cache of %s: %s", carPath, err)
+		return nil
+	}
+	return idx
+}
+
+func saveCarIndex(carPath, indexPath string, idx index.Index) {
+	tmpPath := indexPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		log.Debugf("skip caching index of %s: %s", carPath, err)
+		return
+	}
+	_, err = index.WriteTo(idx, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, indexPath)
+	}
+	if err != nil {
+		log.Warnf("failed to cache index of %s: %s", carPath, err)
+		os.Remove(tmpPath)
+	}
+}
+
+// carIndexCache tells where the indexes generated for the CARv1 files of a restore are kept, so
+// that every CAR is indexed once. They go to a temp directory removed when the restore is done,
+// or next to the CAR files when nextToCar is set.
+type carIndexCache struct {
+	dir   string
+	paths map[string]string
+}
+
+func newCarIndexCache(cars []string, nextToCar bool) *carIndexCache {
+	c := &carIndexCache{paths: make(map[string]string, len(cars))}
+	if nextToCar {
+		for _, carPath := range cars {
+			c.paths[carPath] = carPath + carIndexSuffix
+		}
+		return c
+	}
+	dir, err := os.MkdirTemp("", "graphsplit-index-")
+	if err != nil {
+		log.Warnf("failed to create a temp dir for the CAR indexes, they are generated every time: %s", err)
+		return c
+	}
+	c.dir = dir
+	for i, carPath := range cars {
+		c.paths[carPath] = filepath.Join(dir, strconv.Itoa(i)+carIndexSuffix)
+	}
+	return c
+}
+
+// path returns where the index of carPath is kept, empty when it is not kept
+func (c *carIndexCache) path(carPath string) string {
+	return c.paths[carPath]
+}
+
+// remove deletes the temp directory of the indexes
+func (c *carIndexCache) remove() {
+	if c.dir != "" {
+		if err := os.RemoveAll(c.dir); err != nil {
+			log.Warnf("failed to remove the CAR indexes in %s: %s", c.dir, err)
+		}
+	}
+}
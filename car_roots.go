@@ -0,0 +1,54 @@
+package graphsplit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	"github.com/multiformats/go-multicodec"
+)
+
+// carRoot is a root of a CAR file along with the path inside the output directory it is restored to
+type carRoot struct {
+	node    ipld.Node
+	dagPath string
+}
+
+// carRoots resolves the roots of a CAR file. A single directory root, like the one of the CAR files
+// graphsplit writes, is restored into the output directory itself. With several roots, or a file root
+// as exported by ipfs dag export, every root is restored to an entry named by its CID.
+// Roots that are not UnixFS files or directories are rejected.
+func carRoots(ctx context.Context, carPath string, roots []cid.Cid, dserv ipld.DAGService) ([]*carRoot, error) {
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("car %s has no root", carPath)
+	}
+	res := make([]*carRoot, 0, len(roots))
+	for _, c := range roots {
+		switch codec := multicodec.Code(c.Prefix().Codec); codec {
+		case multicodec.DagPb, multicodec.Raw:
+		default:
+			return nil, fmt.Errorf("root %s of %s is %s, only UnixFS files and directories can be restored", c, carPath, codec)
+		}
+		nd, err := dserv.Get(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root %s of %s: %w", c, carPath, err)
+		}
+		isDir := false
+		if _, ok := nd.(*merkledag.RawNode); !ok {
+			fsn, err := unixfs.ExtractFSNode(nd)
+			if err != nil {
+				return nil, fmt.Errorf("root %s of %s is dag-pb but not UnixFS: %w", c, carPath, err)
+			}
+			isDir = fsn.IsDir()
+		}
+		root := &carRoot{node: nd}
+		if len(roots) > 1 || !isDir {
+			root.dagPath = c.String()
+		}
+		res = append(res, root)
+	}
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package graphsplit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+const (
+	CarVersion1 = 1
+	CarVersion2 = 2
+)
+
+func CheckCarVersion(version int) error {
+	if version != CarVersion1 && version != CarVersion2 {
+		return fmt.Errorf("unsupported car version %d, expect 1 or 2", version)
+	}
+	return nil
+}
+
+// writeCar writes the CARv1 payload held by buf to w. With version 2 the payload is
+// wrapped as a CARv2 with an embedded multihash index, the payload itself is unmodified
+// so its commP stays the same.
+func writeCar(w io.Writer, buf *Buffer, version int) error {
+	if version != CarVersion2 {
+		_, err := io.Copy(w, buf)
+		return err
+	}
+	return carv2.WrapV1(bytes.NewReader(buf.Bytes()), w)
+}
+
+// carPayload returns the CARv1 payload of a CARv1 or CARv2 file along with its size and the car version
+func carPayload(r io.ReaderAt, size int64) (carv2.SectionReader, int64, uint64, error) {
+	cr, err := carv2.NewReader(r)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("not a car file: %w", err)
+	}
+	if cr.Version == CarVersion2 {
+		dr, err := cr.DataReader()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return dr, int64(cr.Header.DataSize), cr.Version, nil
+	}
+	return io.NewSectionReader(r, 0, size), size, cr.Version, nil
+}
@@ -0,0 +1,289 @@
+package graphsplit
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CatalogFileName is the name of the file catalog kept in car-dir,
+// it records which piece holds which source file across all chunk runs
+const CatalogFileName = "catalog.db"
+
+const catalogSchema = `
+CREATE TABLE IF NOT EXISTS catalog (
+	source_path TEXT NOT NULL,
+	source_size INTEGER NOT NULL,
+	offset      INTEGER NOT NULL,
+	length      INTEGER NOT NULL,
+	dag_path    TEXT NOT NULL,
+	cid         TEXT NOT NULL,
+	slice_name  TEXT NOT NULL,
+	car_file    TEXT NOT NULL,
+	payload_cid TEXT NOT NULL,
+	piece_cid   TEXT
+);
+CREATE INDEX IF NOT EXISTS catalog_source_path ON catalog (source_path);
+CREATE INDEX IF NOT EXISTS catalog_dag_path ON catalog (dag_path);
+CREATE INDEX IF NOT EXISTS catalog_payload_cid ON catalog (payload_cid);
+CREATE INDEX IF NOT EXISTS catalog_piece_cid ON catalog (piece_cid);
+CREATE INDEX IF NOT EXISTS catalog_car_file ON catalog (car_file);
+`
+
+// CatalogEntry maps a byte range of a source file to the graph slice holding it
+type CatalogEntry struct {
+	SourcePath string `json:"source_path"`
+	SourceSize int64  `json:"source_size"`
+	Offset     int64  `json:"offset"`
+	Length     int64  `json:"length"`
+	DagPath    string `json:"dag_path"`
+	Cid        string `json:"cid"`
+	SliceName  string `json:"slice_name"`
+	CarFile    string `json:"car_file"`
+	PayloadCid string `json:"payload_cid"`
+	PieceCid   string `json:"piece_cid,omitempty"`
+}
+
+// IsSplitPart reports whether the entry only holds a part of its source file
+func (e *CatalogEntry) IsSplitPart() bool {
+	return e.Length != e.SourceSize
+}
+
+// IsCatalogFile reports whether name is the catalog or one of the journal files sqlite keeps next to it
+func IsCatalogFile(name string) bool {
+	switch name {
+	case CatalogFileName, CatalogFileName + "-journal", CatalogFileName + "-wal", CatalogFileName + "-shm":
+		return true
+	}
+	return false
+}
+
+// openCatalog opens the catalog at dbPath. A slice records a byte range of a source file once,
+// the rows a catalog written before got from chunking the same slice again are dropped.
+func openCatalog(dbPath string) (*sql.DB, error) {
+	db, err := openSQLite(dbPath, catalogSchema)
+	if err != nil {
+		return nil, err
+	}
+	var unique bool
+	if err := db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_index_list('catalog') WHERE name = 'catalog_slice_range'").Scan(&unique); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if !unique {
+		if _, err := db.Exec(`DELETE FROM catalog WHERE rowid NOT IN
+				(SELECT MAX(rowid) FROM catalog GROUP BY slice_name, source_path, offset);
+			CREATE UNIQUE INDEX catalog_slice_range ON catalog (slice_name, source_path, offset);`); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to upgrade %s: %w", dbPath, err)
+		}
+	}
+	return db, nil
+}
+
+// AddToCatalog records every file of a graph slice in the catalog of carDir, the files a slice
+// of the same name recorded before are replaced
+func AddToCatalog(carDir string, index *SliceIndex, carFile, pieceCid string) error {
+	var entries []*CatalogEntry
+	var walk func(nd *fsNode, dagPath string)
+	walk = func(nd *fsNode, dagPath string) {
+		if nd.Src != nil {
+			entries = append(entries, &CatalogEntry{
+				SourcePath: nd.Src.Path,
+				SourceSize: nd.Src.Size,
+				Offset:     nd.Src.Offset,
+				Length:     nd.Src.Length,
+				DagPath:    dagPath,
+				Cid:        nd.Hash,
+				SliceName:  index.GraphName,
+				CarFile:    carFile,
+				PayloadCid: index.PayloadCid,
+				PieceCid:   pieceCid,
+			})
+		}
+		for i := range nd.Link {
+			walk(&nd.Link[i], path.Join(dagPath, nd.Link[i].Name))
+		}
+	}
+	walk(index.Root, "")
+
+	db, err := openCatalog(filepath.Join(carDir, CatalogFileName))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO catalog (source_path, source_size, offset, length, dag_path, cid,
+		slice_name, car_file, payload_cid, piece_cid) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		var pieceCid any
+		if e.PieceCid != "" {
+			pieceCid = e.PieceCid
+		}
+		if _, err := stmt.Exec(e.SourcePath, e.SourceSize, e.Offset, e.Length, e.DagPath, e.Cid,
+			e.SliceName, e.CarFile, e.PayloadCid, pieceCid); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// renameInCatalog records that carFile of carDir was renamed to newName after its piece CID,
+// nothing is done when carDir has no catalog
+func renameInCatalog(carDir, carFile, newName, pieceCid string) error {
+	dbPath := filepath.Join(carDir, CatalogFileName)
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil
+	}
+	db, err := openCatalog(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("UPDATE catalog SET car_file = ?, piece_cid = ? WHERE car_file = ?", newName, pieceCid, carFile)
+	return err
+}
+
+// Locate looks up the catalog of carDir for the files matching pattern. The pattern is
+// matched against both the source path and the path inside the graph, it can be a file,
+// a directory or a glob, a glob matches like the restore filters. Parts of a split file are ordered by their offset. It fails with an
+// error wrapping os.ErrNotExist when carDir has no catalog.
+func Locate(carDir, pattern string) ([]*CatalogEntry, error) {
+	dbPath := filepath.Join(carDir, CatalogFileName)
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("no file catalog in %s: %w", carDir, err)
+	}
+	db, err := openCatalog(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `SELECT source_path, source_size, offset, length, dag_path, cid, slice_name, car_file,
+		payload_cid, COALESCE(piece_cid, '') FROM catalog `
+	var args []any
+	glob := strings.ContainsAny(pattern, "*?[")
+	if glob {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q", pattern)
+		}
+		// GLOB only narrows the rows down, its * and ? also match a /
+		switch {
+		case strings.Contains(pattern, `\`):
+		case strings.Contains(pattern, "/"):
+			query += "WHERE source_path GLOB ?1 || '*' OR dag_path GLOB ?1 || '*' "
+			args = append(args, pattern)
+		default:
+			query += "WHERE source_path GLOB '*' || ?1 || '*' OR dag_path GLOB '*' || ?1 || '*' "
+			args = append(args, pattern)
+		}
+	} else {
+		p := path.Clean(pattern)
+		query += `WHERE source_path = ?1 OR dag_path = ?1
+			OR substr(source_path, 1, length(?1) + 1) = ?1 || '/'
+			OR substr(dag_path, 1, length(?1) + 1) = ?1 || '/' `
+		args = append(args, p)
+	}
+	query += "ORDER BY source_path, offset, slice_name"
+
+	entries, err := queryCatalog(db, query, args...)
+	if err != nil || !glob {
+		return entries, err
+	}
+	var matched []*CatalogEntry
+	for _, e := range entries {
+		if matchAnyPattern([]string{pattern}, e.SourcePath) || matchAnyPattern([]string{pattern}, e.DagPath) {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+// catalogSplitParts returns the parts of the split file at dagPath recorded in the catalog of carDir,
+// it returns nothing when there is no catalog
+func catalogSplitParts(carDir, dagPath string) ([]*CatalogEntry, error) {
+	dbPath := filepath.Join(carDir, CatalogFileName)
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, nil
+	}
+	db, err := openCatalog(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	entries, err := queryCatalog(db, `SELECT source_path, source_size, offset, length, dag_path, cid, slice_name,
+		car_file, payload_cid, COALESCE(piece_cid, '') FROM catalog WHERE substr(dag_path, 1, length(?1) + 1) = ?1 || '.'
+		ORDER BY offset`, dagPath)
+	if err != nil {
+		return nil, err
+	}
+	var parts []*CatalogEntry
+	for _, e := range entries {
+		if _, ok := matchSplitPart(path.Base(e.DagPath), path.Base(dagPath)); ok && e.IsSplitPart() {
+			parts = append(parts, e)
+		}
+	}
+	return parts, nil
+}
+
+func queryCatalog(db *sql.DB, query string, args ...any) ([]*CatalogEntry, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*CatalogEntry
+	for rows.Next() {
+		var e CatalogEntry
+		if err := rows.Scan(&e.SourcePath, &e.SourceSize, &e.Offset, &e.Length, &e.DagPath, &e.Cid,
+			&e.SliceName, &e.CarFile, &e.PayloadCid, &e.PieceCid); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+// catalogDagPaths returns the paths inside the graph of every file the catalog of carDir records for
+// carFile, ok is false when there is no catalog or the CAR file is not in it
+func catalogDagPaths(carDir, carFile string) (paths []string, ok bool, err error) {
+	dbPath := filepath.Join(carDir, CatalogFileName)
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, false, nil
+	}
+	db, err := openCatalog(dbPath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer db.Close()
+
+	// a piece file may be named by its piece CID
+	rows, err := db.Query("SELECT dag_path FROM catalog WHERE car_file = ? OR piece_cid = ?", carFile, carFile)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, false, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, len(paths) > 0, rows.Err()
+}
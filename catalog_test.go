@@ -0,0 +1,144 @@
+package graphsplit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocate(t *testing.T) {
+	carDir := t.TempDir()
+
+	// a car-dir without catalog is left as it is
+	if _, err := Locate(carDir, "*"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expect a not found error without catalog, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(carDir, CatalogFileName)); !os.IsNotExist(err) {
+		t.Fatalf("Locate created the catalog: %v", err)
+	}
+
+	dataDir := chunkTestData(t, carDir, 64<<10)
+	all, err := Locate(carDir, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 7 {
+		t.Fatalf("expect the 3 whole files and the 4 parts of a/big.bin, got %d entries", len(all))
+	}
+
+	cases := []struct {
+		pattern string
+		expect  []string
+	}{
+		{"*.txt", []string{"a/b/x.txt", "y.txt"}},
+		{"a/b/x.txt", []string{"a/b/x.txt"}},
+		{filepath.Join(dataDir, "y.txt"), []string{"y.txt"}},
+		{"c", []string{"c/empty.md"}},
+		{filepath.Join(dataDir, "a"), []string{"a/b/x.txt", "a/big.bin.00000000", "a/big.bin.00000001", "a/big.bin.00000002", "a/big.bin.00000003"}},
+		{"missing", nil},
+		{"a/*.txt", nil},
+		{"a/*", []string{"a/b/x.txt", "a/big.bin.00000000", "a/big.bin.00000001", "a/big.bin.00000002", "a/big.bin.00000003"}},
+		{filepath.Join(dataDir, "*.txt"), []string{"y.txt"}},
+	}
+	for _, c := range cases {
+		entries, err := Locate(carDir, c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != len(c.expect) {
+			t.Fatalf("%s: got %d entries, expect %v", c.pattern, len(entries), c.expect)
+		}
+		for i, e := range entries {
+			if e.DagPath != c.expect[i] {
+				t.Fatalf("%s: entry %d is %s, expect %s", c.pattern, i, e.DagPath, c.expect[i])
+			}
+		}
+	}
+
+	// the parts of the split file cover it in order, each in its own slice
+	parts, err := Locate(carDir, "a/big.bin.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	big, err := os.Stat(filepath.Join(dataDir, "a/big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offset int64
+	slices := make(map[string]bool)
+	for _, p := range parts {
+		if !p.IsSplitPart() || p.SourceSize != big.Size() || p.Offset != offset || p.Length <= 0 {
+			t.Fatalf("unexpected part %+v at offset %d", p, offset)
+		}
+		if _, err := os.Stat(filepath.Join(carDir, p.CarFile)); err != nil {
+			t.Fatal(err)
+		}
+		offset += p.Length
+		slices[p.SliceName] = true
+	}
+	if offset != big.Size() || len(slices) != len(parts) {
+		t.Fatalf("%d parts in %d slices cover %d of %d bytes", len(parts), len(slices), offset, big.Size())
+	}
+
+	// chunking a slice again replaces its files
+	index, err := ReadSliceIndex(filepath.Join(carDir, parts[0].CarFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToCatalog(carDir, index, parts[0].CarFile, "baga-piece"); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Locate(carDir, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != len(all) {
+		t.Fatalf("expect %d entries after chunking a slice again, got %d", len(all), len(again))
+	}
+	for _, e := range again {
+		if (e.SliceName == parts[0].SliceName) != (e.PieceCid == "baga-piece") {
+			t.Fatalf("unexpected entry %+v", e)
+		}
+	}
+}
+
+func TestCatalogUpgrade(t *testing.T) {
+	carDir := t.TempDir()
+	// a catalog written before the files of a slice were unique holds them once per chunk run
+	db, err := openSQLite(filepath.Join(carDir, CatalogFileName), catalogSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pieceCid := range []string{"baga-old", "baga-new"} {
+		_, err := db.Exec(`INSERT INTO catalog (source_path, source_size, offset, length, dag_path, cid,
+			slice_name, car_file, payload_cid, piece_cid) VALUES ('/data/x', 5, 0, 5, 'x', 'cid', 'slice-1', 'slice-1.car', 'payload', ?)`, pieceCid)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	entries, err := Locate(carDir, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].PieceCid != "baga-new" {
+		t.Fatalf("expect the latest entry only, got %d entries", len(entries))
+	}
+}
+
+func TestIsCatalogFile(t *testing.T) {
+	for name, expect := range map[string]bool{
+		"catalog.db":         true,
+		"catalog.db-journal": true,
+		"catalog.db-wal":     true,
+		"catalog.db.car":     false,
+		"catalog.dbx":        false,
+		"my-catalog.db":      false,
+	} {
+		if IsCatalogFile(name) != expect {
+			t.Fatalf("IsCatalogFile(%q) is %t", name, !expect)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package graphsplit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"lukechampine.com/blake3"
+)
+
+// checksum algorithms of the source files, recorded in the sidecars as "<algo>:<hex digest>"
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumBLAKE3 = "blake3"
+	ChecksumNone   = "none"
+)
+
+// ErrChecksumMismatch is returned when a restored file differs from the checksum recorded at chunk time
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+func CheckChecksumAlgo(algo string) error {
+	switch algo {
+	case ChecksumSHA256, ChecksumBLAKE3, ChecksumNone, "":
+		return nil
+	}
+	return fmt.Errorf("unsupported checksum %q, expect one of sha256, blake3, none", algo)
+}
+
+// newChecksumHash returns the hash of algo, nil when no checksum is computed
+func newChecksumHash(algo string) hash.Hash {
+	switch algo {
+	case ChecksumSHA256:
+		return sha256.New()
+	case ChecksumBLAKE3:
+		return blake3.New(32, nil)
+	}
+	return nil
+}
+
+func formatChecksum(algo string, h hash.Hash) string {
+	return algo + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// checksumRange computes the checksum of the byte range [offset, offset+length) of fpath with
+// the algorithm of expected, a checksum recorded in a sidecar
+func checksumRange(fpath string, offset, length int64, expected string) (string, error) {
+	algo, _, ok := strings.Cut(expected, ":")
+	h := newChecksumHash(algo)
+	if !ok || h == nil {
+		return "", fmt.Errorf("unsupported checksum %q", expected)
+	}
+	f, err := os.Open(fpath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	n, err := io.Copy(h, io.NewSectionReader(f, offset, length))
+	if err != nil {
+		return "", err
+	}
+	if n != length {
+		return "", fmt.Errorf("%s has %d bytes at offset %d, expect %d", fpath, n, offset, length)
+	}
+	return formatChecksum(algo, h), nil
+}
+
+// fileHashes carries the checksums of the split files from slice to slice while they are chunked,
+// the parts of a file are always chunked in order
+type fileHashes struct {
+	algo  string
+	mu    sync.Mutex
+	files map[string]hash.Hash
+}
+
+func newFileHashes(algo string) *fileHashes {
+	if algo == ChecksumNone {
+		algo = ""
+	}
+	return &fileHashes{algo: algo, files: make(map[string]hash.Hash)}
+}
+
+// writers returns the hashes item is read into: the checksum of the item itself and, for a part
+// of a split file, the checksum of the whole file
+func (fh *fileHashes) writers(item Finfo) (part, file hash.Hash) {
+	if fh == nil || fh.algo == "" {
+		return nil, nil
+	}
+	part = newChecksumHash(fh.algo)
+	if item.SeekStart == 0 && item.SeekEnd == 0 {
+		return part, nil
+	}
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	file, ok := fh.files[item.Path]
+	if !ok || item.SeekStart == 0 {
+		file = newChecksumHash(fh.algo)
+		fh.files[item.Path] = file
+	}
+	return part, file
+}
+
+// done returns the checksums of item once it is read, the checksum of the whole file is only
+// returned with its last part
+func (fh *fileHashes) done(item Finfo, part, file hash.Hash) (string, string) {
+	if part == nil {
+		return "", ""
+	}
+	if file == nil || item.SeekEnd < item.Info.Size()-1 {
+		return formatChecksum(fh.algo, part), ""
+	}
+	fh.mu.Lock()
+	delete(fh.files, item.Path)
+	fh.mu.Unlock()
+	return formatChecksum(fh.algo, part), formatChecksum(fh.algo, file)
+}
+
+// carChecksumSuffix is the suffix of the SHA-256 of a CAR file, written next to it in the format
+// of sha256sum so the copies can be checked with sha256sum -c
+const carChecksumSuffix = ".sha256"
+
+func CarChecksumPath(carPath string) string {
+	return carPath + carChecksumSuffix
+}
+
+func IsCarChecksum(name string) bool {
+	return strings.HasSuffix(name, carChecksumSuffix)
+}
+
+func writeCarChecksum(carPath, sum string) error {
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(carPath))
+	return os.WriteFile(CarChecksumPath(carPath), []byte(line), 0o644)
+}
+
+// renameCarChecksum follows the rename of a CAR file, the checksum names the file it is of
+func renameCarChecksum(carPath, newPath string) error {
+	data, err := os.ReadFile(CarChecksumPath(carPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sum, _, _ := strings.Cut(string(data), " ")
+	if err := writeCarChecksum(newPath, sum); err != nil {
+		return err
+	}
+	return os.Remove(CarChecksumPath(carPath))
+}
@@ -2,15 +2,13 @@ package graphsplit
 
 import (
 	"context"
-	"encoding/csv"
 	"fmt"
-	"io"
 	"os"
 	"path"
 	"path/filepath"
-	"strconv"
 	"time"
 
+	"github.com/filecoin-project/go-state-types/abi"
 	logging "github.com/ipfs/go-log/v2"
 )
 
@@ -21,76 +19,79 @@ type GraphBuildCallback interface {
 	OnError(error)
 }
 
+// SliceIndexCallback is a GraphBuildCallback that gets the file index of the slice along, Chunk
+// calls OnSliceIndexSuccess instead of OnSuccess when the callback implements it
+type SliceIndexCallback interface {
+	GraphBuildCallback
+	OnSliceIndexSuccess(buf *Buffer, graphName, payloadCid, fsDetail string, index *SliceIndex)
+}
+
 type commPCallback struct {
-	carDir     string
-	rename     bool
-	addPadding bool
+	carDir         string
+	rename         bool
+	addPadding     bool
+	manifestFormat string
+	carVersion     int
+	carChecksum    bool
+	commPWorkers   int
+	padTo          int64
 }
 
 func (cc *commPCallback) OnSuccess(buf *Buffer, graphName, payloadCid, fsDetail string) {
-	commpStartTime := time.Now()
+	cc.OnSliceIndexSuccess(buf, graphName, payloadCid, fsDetail, nil)
+}
 
-	log.Info("start to calculate pieceCID")
-	cpRes, err := CalcCommPV2(buf, cc.addPadding)
+// OnSliceIndexSuccess writes the slice index next to the CAR file and adds it to the catalog,
+// nothing is written without index
+func (cc *commPCallback) OnSliceIndexSuccess(buf *Buffer, graphName, payloadCid, fsDetail string, index *SliceIndex) {
+	// the piece CID names the CAR file, it is written under a temporary name while the piece is computed
+	log.Infof("start to write car and calculate pieceCID")
+	writeStart := time.Now()
+	tmpPath := filepath.Join(cc.carDir, payloadCid+".car.tmp")
+	// a CARv2 is never padded, its piece is made of the inner CARv1 payload
+	cpRes, sum, err := writeCarFile(tmpPath, buf, cc.carVersion, cc.addPadding && cc.carVersion != CarVersion2, true, cc.carChecksum, cc.commPWorkers, cc.padTo)
 	if err != nil {
 		log.Fatalf("calculation of pieceCID failed: %s", err)
 	}
-	log.Infof("calculation of pieceCID completed, time elapsed: %s", time.Since(commpStartTime))
-	log.Infof("piece cid: %s, payload size: %d, size: %d ", cpRes.Root.String(), cpRes.PayloadSize, cpRes.Size)
+	buf.Reset()
+	log.Infof("end write car and calculation of pieceCID, time elapsed: %s", time.Since(writeStart))
+	log.Infof("piece cid: %s, piece cid v2: %s, payload size: %d, size: %d ", cpRes.Root.String(), cpRes.RootV2.String(), cpRes.PayloadSize, cpRes.Size)
 
-	buf.SeekStart()
 	carFilePath := filepath.Join(cc.carDir, cpRes.Root.String())
 	carFileNameWithSuffix := carFilePath + ".car"
-
-	log.Infof("start write car to tile")
-	writeStart := time.Now()
-	carFile, err := os.OpenFile(carFileNameWithSuffix, os.O_RDWR|os.O_CREATE, 0o644)
-	if err != nil {
-		log.Fatalf("failed to create car file: %s", err)
+	if cc.rename {
+		carFileNameWithSuffix = carFilePath
 	}
-
-	if _, err = io.Copy(carFile, buf); err != nil {
-		log.Fatalf("failed to write car file: %s", err)
+	if err := os.Rename(tmpPath, carFileNameWithSuffix); err != nil {
+		log.Fatalf("failed to rename car file: %s", err)
 	}
-	buf.Reset()
-	carFile.Close()
-	log.Infof("end write car to file: %v", time.Since(writeStart))
-
-	if cc.rename {
-		if err := os.Rename(carFileNameWithSuffix, carFilePath); err != nil {
-			log.Fatalf("failed to rename car file: %s", err)
+	if sum != "" {
+		if err := writeCarChecksum(carFileNameWithSuffix, sum); err != nil {
+			log.Fatalf("failed to write car checksum: %s", err)
 		}
 	}
 
-	// Add node inof to manifest.csv
-	manifestPath := path.Join(cc.carDir, "manifest.csv")
-	_, err = os.Stat(manifestPath)
-	if err != nil && !os.IsNotExist(err) {
-		log.Fatal(err)
-	}
-	var isCreateAction bool
-	if err != nil && os.IsNotExist(err) {
-		isCreateAction = true
+	if index != nil {
+		carFileName := filepath.Base(carFileNameWithSuffix)
+		if err := WriteSliceIndex(carFilePath, index); err != nil {
+			log.Fatalf("failed to write slice index: %s", err)
+		}
+		if err := AddToCatalog(cc.carDir, index, carFileName, cpRes.Root.String()); err != nil {
+			log.Fatalf("failed to update file catalog: %s", err)
+		}
 	}
-	f, err := os.OpenFile(manifestPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+
+	// Add node inof to manifest
+	entry, err := newManifestEntry(payloadCid, graphName, fsDetail)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer f.Close()
-
-	csvWriter := csv.NewWriter(f)
-	csvWriter.UseCRLF = true
-	defer csvWriter.Flush()
-	if isCreateAction {
-		csvWriter.Write([]string{
-			"payload_cid", "filename", "piece_cid", "payload_size", "piece_size", "detail",
-		})
-	}
-
-	if err := csvWriter.Write([]string{
-		payloadCid, graphName, cpRes.Root.String(),
-		strconv.FormatInt(cpRes.PayloadSize, 10), strconv.FormatUint(uint64(cpRes.Size), 10), fsDetail,
-	}); err != nil {
+	entry.HasCommP = true
+	entry.PieceCid = cpRes.Root.String()
+	entry.PieceCidV2 = cpRes.RootV2.String()
+	entry.PayloadSize = cpRes.PayloadSize
+	entry.PieceSize = uint64(cpRes.Size)
+	if err := AppendManifest(cc.carDir, cc.manifestFormat, entry); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -100,36 +101,44 @@ func (cc *commPCallback) OnError(err error) {
 }
 
 type csvCallback struct {
-	carDir string
+	carDir         string
+	manifestFormat string
+	carVersion     int
+	carChecksum    bool
 }
 
 func (cc *csvCallback) OnSuccess(buf *Buffer, graphName, payloadCid, fsDetail string) {
-	// Add node inof to manifest.csv
-	manifestPath := path.Join(cc.carDir, "manifest.csv")
-	_, err := os.Stat(manifestPath)
-	if err != nil && !os.IsNotExist(err) {
-		log.Fatal(err)
-	}
-	var isCreateAction bool
-	if err != nil && os.IsNotExist(err) {
-		isCreateAction = true
-	}
-	f, err := os.OpenFile(manifestPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	cc.OnSliceIndexSuccess(buf, graphName, payloadCid, fsDetail, nil)
+}
+
+// OnSliceIndexSuccess writes the slice index next to the CAR file and adds it to the catalog,
+// nothing is written without index
+func (cc *csvCallback) OnSliceIndexSuccess(buf *Buffer, graphName, payloadCid, fsDetail string, index *SliceIndex) {
+	carFilePath := path.Join(cc.carDir, payloadCid+".car")
+	_, sum, err := writeCarFile(carFilePath, buf, cc.carVersion, false, false, cc.carChecksum, 0, 0)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer f.Close()
-	if isCreateAction {
-		if _, err := f.Write([]byte("payload_cid,filename,detail")); err != nil {
+	if sum != "" {
+		if err := writeCarChecksum(carFilePath, sum); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if index != nil {
+		if err := WriteSliceIndex(carFilePath, index); err != nil {
+			log.Fatal(err)
+		}
+		if err := AddToCatalog(cc.carDir, index, payloadCid+".car", ""); err != nil {
 			log.Fatal(err)
 		}
 	}
 
-	if err := os.WriteFile(path.Join(cc.carDir, payloadCid+".car"), buf.Bytes(), 0o644); err != nil {
+	// Add node inof to manifest
+	entry, err := newManifestEntry(payloadCid, graphName, fsDetail)
+	if err != nil {
 		log.Fatal(err)
 	}
-
-	if _, err := f.Write([]byte(fmt.Sprintf("%s,%s,%s", payloadCid, graphName, fsDetail))); err != nil {
+	if err := AppendManifest(cc.carDir, cc.manifestFormat, entry); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -145,12 +154,78 @@ func (cc *errCallback) OnError(err error) {
 	log.Fatal(err)
 }
 
+// CallbackParams configures the CAR files written by CommPCallbackWithParams and CSVCallbackWithParams
+type CallbackParams struct {
+	// ManifestFormat is the format of the manifest of the slices, csv when empty
+	ManifestFormat string
+	// CarVersion is the version of the CAR files, CARv1 when 0
+	CarVersion int
+	// CarChecksum writes the SHA-256 of every CAR file next to it
+	CarChecksum bool
+	// PieceParams configure the pieces CommPCallbackWithParams computes as the CAR files are
+	// written, a CARv2 is never padded. They are ignored by CSVCallbackWithParams.
+	PieceParams
+}
+
+// check fills in the defaults of params and validates them
+func (params *CallbackParams) check() error {
+	if params.ManifestFormat == "" {
+		params.ManifestFormat = ManifestFormatCSV
+	}
+	if err := CheckManifestFormat(params.ManifestFormat); err != nil {
+		return err
+	}
+	if params.CarVersion == 0 {
+		params.CarVersion = CarVersion1
+	}
+	return CheckCarVersion(params.CarVersion)
+}
+
+// CommPCallback writes the CAR files named by their piece CID, computed as they are written
 func CommPCallback(carDir string, rename, addPadding bool) GraphBuildCallback {
-	return &commPCallback{carDir: carDir, rename: rename, addPadding: addPadding}
+	cb, _ := CommPCallbackWithParams(carDir, &CallbackParams{PieceParams: PieceParams{Rename: rename, AddPadding: addPadding}})
+	return cb
+}
+
+// CommPCallbackWithParams is CommPCallback with the CAR files, their pieces and the manifest
+// configured by params
+func CommPCallbackWithParams(carDir string, params *CallbackParams) (GraphBuildCallback, error) {
+	p := *params
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	if p.PadTo != 0 {
+		if err := abi.PaddedPieceSize(p.PadTo).Validate(); err != nil {
+			return nil, fmt.Errorf("invalid piece size %d to pad to: %w", p.PadTo, err)
+		}
+		if p.CarVersion == CarVersion2 {
+			return nil, fmt.Errorf("a CARv2 cannot be padded to a piece size, its piece is made of the inner CARv1 payload")
+		}
+	}
+	return &commPCallback{
+		carDir:         carDir,
+		rename:         p.Rename,
+		addPadding:     p.AddPadding,
+		manifestFormat: p.ManifestFormat,
+		carVersion:     p.CarVersion,
+		carChecksum:    p.CarChecksum,
+		commPWorkers:   CommPWorkers(p.CommPWorkers),
+		padTo:          p.PadTo,
+	}, nil
 }
 
 func CSVCallback(carDir string) GraphBuildCallback {
-	return &csvCallback{carDir: carDir}
+	cb, _ := CSVCallbackWithParams(carDir, &CallbackParams{})
+	return cb
+}
+
+// CSVCallbackWithParams is CSVCallback with the CAR files and the manifest configured by params
+func CSVCallbackWithParams(carDir string, params *CallbackParams) (GraphBuildCallback, error) {
+	p := *params
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	return &csvCallback{carDir: carDir, manifestFormat: p.ManifestFormat, carVersion: p.CarVersion, carChecksum: p.CarChecksum}, nil
 }
 
 func ErrCallback() GraphBuildCallback {
@@ -169,6 +244,13 @@ type ChunkParams struct {
 	RandomRenameSourceFile bool
 	RandomSelectFile       bool
 	SkipFilename           bool
+	// Checksum is the algorithm of the checksums of the source files recorded in the sidecars,
+	// sha256 or blake3, no checksum is computed when empty or none
+	Checksum string
+	// SectorSize is the sector size the pieces are made for, the default sector size when 0
+	SectorSize int64
+
+	hashes *fileHashes
 }
 
 func Chunk(ctx context.Context, params *ChunkParams) error {
@@ -184,6 +266,19 @@ func Chunk(ctx context.Context, params *ChunkParams) error {
 	if params.ParentPath == "" {
 		params.ParentPath = params.TargetPath
 	}
+	if err := CheckChecksumAlgo(params.Checksum); err != nil {
+		return err
+	}
+	params.hashes = newFileHashes(params.Checksum)
+	if params.SectorSize == 0 {
+		params.SectorSize = DefaultSectorSize
+	}
+	if err := CheckSectorSize(params.SectorSize); err != nil {
+		return err
+	}
+	if params.ExpectSliceSize > SectorCapacity(params.SectorSize) {
+		return fmt.Errorf("slice size %d exceeds the capacity of the sector, %d bytes", params.ExpectSliceSize, SectorCapacity(params.SectorSize))
+	}
 
 	partSliceSize := params.ExpectSliceSize - params.Ef.sliceSize
 	args := []string{params.TargetPath}
@@ -4,13 +4,16 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/docker/go-units"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filedrive-team/go-graphsplit"
 	"github.com/filedrive-team/go-graphsplit/config"
 	"github.com/filedrive-team/go-graphsplit/dataset"
+	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/urfave/cli/v2"
 )
@@ -24,6 +27,10 @@ func main() {
 		restoreCmd,
 		commpCmd,
 		importDatasetCmd,
+		locateCmd,
+		catCmd,
+		getCmd,
+		verifyCmd,
 	}
 
 	app := &cli.App{
@@ -86,6 +93,10 @@ var chunkCmd = &cli.Command{
 			Value: false,
 			Usage: "add padding to carfile in order to convert it to piece file",
 		},
+		&cli.StringFlag{
+			Name:  "pad-to",
+			Usage: "pad every carfile with zeros to this piece size, a power of two such as 32GiB, and compute the piece of the padded file",
+		},
 		&cli.StringFlag{
 			Name:    "config",
 			Usage:   "config file path",
@@ -104,6 +115,31 @@ var chunkCmd = &cli.Command{
 			Name:  "skip-filename",
 			Usage: "manifest csv detail not contain filename",
 		},
+		&cli.StringFlag{
+			Name:  "manifest-format",
+			Value: graphsplit.ManifestFormatCSV,
+			Usage: "specify manifest format, csv, jsonl or sqlite",
+		},
+		&cli.BoolFlag{
+			Name:  "car-sha256",
+			Value: false,
+			Usage: "write the SHA-256 of every CAR file next to it, as <car file>.sha256 in the format of sha256sum",
+		},
+		commpWorkersFlag,
+		&cli.StringFlag{
+			Name:  "sector-size",
+			Usage: "specify the sector size the pieces are made for, 2KiB, 8MiB, 512MiB, 32GiB or 64GiB, by default SectorSize of the config file or 32GiB",
+		},
+		&cli.IntFlag{
+			Name:  "car-version",
+			Value: graphsplit.CarVersion1,
+			Usage: "specify CAR version, 1 or 2 (CARv2 with an embedded index)",
+		},
+		&cli.StringFlag{
+			Name:  "checksum",
+			Value: graphsplit.ChecksumSHA256,
+			Usage: "checksum of the source files and split parts recorded in the sidecars, sha256, blake3 or none",
+		},
 	},
 	ArgsUsage: "<input path>",
 	Action: func(c *cli.Context) error {
@@ -115,9 +151,24 @@ var chunkCmd = &cli.Command{
 		randomRenameSourceFile := c.Bool("random-rename-source-file")
 		randomSelectFile := c.Bool("random-select-file")
 		skipFilename := c.Bool("skip-filename")
+		manifestFormat := c.String("manifest-format")
 		if !graphsplit.ExistDir(carDir) {
 			return fmt.Errorf("the path of car-dir does not exist")
 		}
+		if err := graphsplit.CheckManifestFormat(manifestFormat); err != nil {
+			return err
+		}
+		carVersion := c.Int("car-version")
+		if err := graphsplit.CheckCarVersion(carVersion); err != nil {
+			return err
+		}
+		checksum := c.String("checksum")
+		if err := graphsplit.CheckChecksumAlgo(checksum); err != nil {
+			return err
+		}
+		if carVersion == graphsplit.CarVersion2 && c.Bool("add-padding") {
+			return fmt.Errorf("add-padding is not supported with CARv2, the piece is made of the inner CARv1 payload")
+		}
 
 		cfgPath := c.String("config")
 		if cfgPath == "" {
@@ -152,25 +203,64 @@ var chunkCmd = &cli.Command{
 				return fmt.Errorf("failed to parse real file size: %v", err)
 			}
 		}
-		if sliceSize+int(extraFileSliceSize) > 32*graphsplit.Gib {
-			return fmt.Errorf("slice size %d + extra file slice size %d exceeds 32 GiB", sliceSize, extraFileSliceSize)
+		if c.IsSet("sector-size") {
+			cfg.SectorSize = c.String("sector-size")
+		}
+		sectorSize, err := graphsplit.ParseSectorSize(cfg.SectorSize)
+		if err != nil {
+			return err
+		}
+		if capacity := graphsplit.SectorCapacity(sectorSize); int64(sliceSize)+extraFileSliceSize > capacity {
+			return fmt.Errorf("slice size %d + extra file slice size %d exceeds the sector capacity of %d bytes", sliceSize, extraFileSliceSize, capacity)
+		}
+		padTo, err := graphsplit.ParsePadTo(c.String("pad-to"), sectorSize)
+		if err != nil {
+			return err
+		}
+		if padTo != 0 {
+			if !c.Bool("calc-commp") {
+				return fmt.Errorf("pad-to needs calc-commp, the pieces of the padded CAR files are computed as they are written")
+			}
+			if capacity := int64(abi.PaddedPieceSize(padTo).Unpadded()); int64(sliceSize)+extraFileSliceSize > capacity {
+				return fmt.Errorf("slice size %d + extra file slice size %d exceeds the %d bytes of a piece padded to %s", sliceSize, extraFileSliceSize, capacity, c.String("pad-to"))
+			}
 		}
 		log.Infof("extra file slice size: %d, random rename source file: %v, random select file: %v", extraFileSliceSize, randomRenameSourceFile, randomSelectFile)
 		log.Infof("skip filename: %v", skipFilename)
-		ef, err := graphsplit.NewExtraFile(strings.TrimSuffix(cfg.ExtraFilePath, "/"), int64(extraFileSliceSize), int64(sliceSize), randomRenameSourceFile)
+		ef, err := graphsplit.NewExtraFileWithParams(&graphsplit.ExtraFileParams{
+			Path:                   strings.TrimSuffix(cfg.ExtraFilePath, "/"),
+			SliceSize:              int64(extraFileSliceSize),
+			PieceRawSize:           int64(sliceSize),
+			RandomRenameSourceFile: randomRenameSourceFile,
+			SectorSize:             sectorSize,
+		})
 		if err != nil {
 			return err
 		}
 
 		targetPath := strings.TrimSuffix(c.Args().First(), "/")
+		cbParams := &graphsplit.CallbackParams{
+			ManifestFormat: manifestFormat,
+			CarVersion:     carVersion,
+			CarChecksum:    c.Bool("car-sha256"),
+			PieceParams: graphsplit.PieceParams{
+				Rename:       c.Bool("rename"),
+				AddPadding:   c.Bool("add-padding"),
+				CommPWorkers: c.Int("commp-workers"),
+				PadTo:        padTo,
+			},
+		}
 		var cb graphsplit.GraphBuildCallback
 		if c.Bool("calc-commp") {
-			cb = graphsplit.CommPCallback(carDir, c.Bool("rename"), c.Bool("add-padding"))
+			cb, err = graphsplit.CommPCallbackWithParams(carDir, cbParams)
 		} else if c.Bool("save-manifest") {
-			cb = graphsplit.CSVCallback(carDir)
+			cb, err = graphsplit.CSVCallbackWithParams(carDir, cbParams)
 		} else {
 			cb = graphsplit.ErrCallback()
 		}
+		if err != nil {
+			return err
+		}
 
 		params := graphsplit.ChunkParams{
 			ExpectSliceSize:        int64(sliceSize),
@@ -184,6 +274,8 @@ var chunkCmd = &cli.Command{
 			RandomRenameSourceFile: randomRenameSourceFile,
 			RandomSelectFile:       randomSelectFile,
 			SkipFilename:           skipFilename,
+			Checksum:               checksum,
+			SectorSize:             sectorSize,
 		}
 
 		loop := c.Bool("loop")
@@ -223,15 +315,67 @@ var restoreCmd = &cli.Command{
 			Usage:    "specify source car path, directory or file",
 		},
 		&cli.StringFlag{
-			Name:     "output-dir",
-			Required: true,
-			Usage:    "specify output directory",
+			Name:  "output-dir",
+			Usage: "specify output directory",
+		},
+		&cli.StringFlag{
+			Name:  "tar",
+			Usage: "write the restored tree as a tar stream to this file, or - for stdout, instead of output-dir",
 		},
 		&cli.IntFlag{
 			Name:  "parallel",
 			Value: 4,
 			Usage: "specify how many number of goroutines runs when generate file node",
 		},
+		&cli.StringSliceFlag{
+			Name:  "include",
+			Usage: "only restore the paths matching this glob, relative to the graph root, can be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "do not restore the paths matching this glob, relative to the graph root, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "report",
+			Usage: "write the json report of incomplete split files and failures to this file, by default it is printed when something is incomplete",
+		},
+		&cli.StringFlag{
+			Name:  "max-bytes",
+			Usage: "abort the restore when it writes more than this size in total, e.g. 100GiB, no limit by default",
+		},
+		&cli.Int64Flag{
+			Name:  "max-entries",
+			Usage: "abort the restore when it writes more than this number of files, directories and symlinks, no limit by default",
+		},
+		&cli.IntFlag{
+			Name:  "max-depth",
+			Usage: "abort the restore when a path is nested deeper than this, no limit by default",
+		},
+		&cli.StringFlag{
+			Name:  "on-conflict",
+			Value: graphsplit.ConflictOverwrite,
+			Usage: "what to do with entries that already exist in output-dir: overwrite, skip, rename or fail",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "list what would be written, with sizes and conflicts, without writing anything",
+		},
+		&cli.BoolFlag{
+			Name:  "resume",
+			Usage: "resume an interrupted restore to output-dir, skip the CAR files it completed",
+		},
+		&cli.BoolFlag{
+			Name:  "keep-going",
+			Usage: "restore as much as possible when a CAR file or an entry fails, and list every failure at the end",
+		},
+		&cli.BoolFlag{
+			Name:  "verify",
+			Usage: "check the restored files against the checksums recorded at chunk time, mismatches are listed in the report",
+		},
+		&cli.BoolFlag{
+			Name:  "cache-index",
+			Usage: "keep the block index of a CARv1 next to it as <car>.idx for later restores and cat, by default it is removed after the restore",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		parallel := c.Int("parallel")
@@ -240,18 +384,128 @@ var restoreCmd = &cli.Command{
 		if parallel <= 0 {
 			return fmt.Errorf("Unexpected! Parallel has to be greater than 0")
 		}
+		onConflict, dryRun := c.String("on-conflict"), c.Bool("dry-run")
+		if err := graphsplit.CheckConflictPolicy(onConflict); err != nil {
+			return err
+		}
+		filter, err := graphsplit.NewPathFilter(c.StringSlice("include"), c.StringSlice("exclude"))
+		if err != nil {
+			return err
+		}
+		limits := graphsplit.RestoreLimits{
+			MaxEntries: c.Int64("max-entries"),
+			MaxDepth:   c.Int("max-depth"),
+		}
+		if maxBytes := c.String("max-bytes"); maxBytes != "" {
+			if limits.MaxBytes, err = units.RAMInBytes(maxBytes); err != nil {
+				return fmt.Errorf("invalid max-bytes %q: %w", maxBytes, err)
+			}
+		}
+		if tarPath := c.String("tar"); tarPath != "" {
+			if dryRun || c.Bool("resume") || c.Bool("verify") || c.Bool("keep-going") || c.IsSet("on-conflict") {
+				return fmt.Errorf("tar cannot be used with dry-run, resume, verify, keep-going or on-conflict")
+			}
+			return restoreTar(&graphsplit.RestoreParams{CarPath: carPath, Filter: filter, Limits: limits, CacheIndex: c.Bool("cache-index")}, tarPath)
+		}
+		if outputDir == "" {
+			return fmt.Errorf("output-dir or tar is required")
+		}
 
-		graphsplit.CarTo(carPath, outputDir, parallel)
-		graphsplit.Merge(outputDir, parallel)
+		report, err := graphsplit.Restore(context.Background(), &graphsplit.RestoreParams{
+			CarPath:    carPath,
+			OutputDir:  outputDir,
+			Parallel:   parallel,
+			Filter:     filter,
+			Limits:     limits,
+			OnConflict: onConflict,
+			DryRun:     dryRun,
+			Resume:     c.Bool("resume"),
+			KeepGoing:  c.Bool("keep-going"),
+			Verify:     c.Bool("verify"),
+			CacheIndex: c.Bool("cache-index"),
+		})
+		if err != nil {
+			if reportPath := c.String("report"); report != nil && (reportPath != "" || len(report.Mismatches) > 0) {
+				if reportPath == "" {
+					reportPath = "-"
+				}
+				if serr := report.Save(reportPath); serr != nil {
+					log.Error(serr)
+				}
+			}
+			return err
+		}
+		if c.Bool("verify") && !dryRun {
+			fmt.Printf("verified %d files and parts, %d without checksum\n", report.Verified, report.Unverified)
+		}
+		if dryRun {
+			var total int64
+			var conflicts int
+			for _, e := range report.Entries {
+				total += e.Size
+				line := fmt.Sprintf("%-9s %-7s %12d  %s", e.Action, e.Type, e.Size, e.Path)
+				if e.RenameTo != "" {
+					line += " -> " + e.RenameTo
+				}
+				if e.Conflict() {
+					conflicts++
+				}
+				fmt.Println(line)
+			}
+			fmt.Printf("dry run: %d entries, %d bytes, %d conflicts\n", len(report.Entries), total, conflicts)
+			if onConflict == graphsplit.ConflictFail && conflicts > 0 {
+				return fmt.Errorf("%d entries already exist in %s", conflicts, outputDir)
+			}
+		}
+		if reportPath := c.String("report"); reportPath != "" || !report.Complete() {
+			if reportPath == "" {
+				reportPath = "-"
+			}
+			if err := report.Save(reportPath); err != nil {
+				return err
+			}
+		}
+		if !report.Complete() {
+			return fmt.Errorf("%d files are incomplete, restore the missing slices listed in the report", len(report.Incomplete))
+		}
 
-		fmt.Println("completed!")
+		if !dryRun {
+			fmt.Println("completed!")
+		}
 		return nil
 	},
 }
 
+func restoreTar(params *graphsplit.RestoreParams, tarPath string) error {
+	if tarPath == "-" {
+		return graphsplit.RestoreTar(context.Background(), params, os.Stdout)
+	}
+	f, err := os.Create(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := graphsplit.RestoreTar(context.Background(), params, f); err != nil {
+		os.Remove(tarPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Println("completed!")
+	return nil
+}
+
+var commpWorkersFlag = &cli.IntFlag{
+	Name:  "commp-workers",
+	Value: 0,
+	Usage: "specify how many goroutines compute the pieceCID of a CAR file, 0 uses all the cores",
+}
+
 var commpCmd = &cli.Command{
-	Name:  "commP",
-	Usage: "PieceCID and PieceSize calculation",
+	Name:      "commP",
+	Usage:     "PieceCID and PieceSize calculation",
+	ArgsUsage: "<car file, directory or glob>...",
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
 			Name:  "rename",
@@ -263,21 +517,149 @@ var commpCmd = &cli.Command{
 			Value: false,
 			Usage: "add padding to carfile in order to convert it to piece file",
 		},
+		&cli.StringFlag{
+			Name:  "pad-to",
+			Usage: "pad every carfile with zeros to this piece size, a power of two such as 32GiB, and compute the piece of the padded file",
+		},
+		&cli.StringFlag{
+			Name:  "verify-manifest",
+			Usage: "recompute the piece CIDs of the CAR and piece files listed in this manifest and compare them with it",
+		},
+		&cli.StringFlag{
+			Name:  "convert",
+			Usage: "convert a piece CID v1 to the piece CID v2 with --payload-size, and --pad-to for a padded piece, or a piece CID v2 to the piece CID v1",
+		},
+		&cli.Int64Flag{
+			Name:  "payload-size",
+			Usage: "specify the payload size of the piece CID v1 to convert",
+		},
+		&cli.IntFlag{
+			Name:  "parallel",
+			Value: 4,
+			Usage: "specify how many pieces are computed at once",
+		},
+		&cli.BoolFlag{
+			Name:  "save-manifest",
+			Value: false,
+			Usage: "write the pieces to the manifest next to the CAR files, filling in the slices already there",
+		},
+		&cli.StringFlag{
+			Name:  "manifest-dir",
+			Usage: "with save-manifest, write the pieces to the manifest of this directory instead",
+		},
+		&cli.StringFlag{
+			Name:  "manifest-format",
+			Value: graphsplit.ManifestFormatCSV,
+			Usage: "specify manifest format, csv, jsonl or sqlite",
+		},
+		commpWorkersFlag,
+		&cli.StringFlag{
+			Name:  "sector-size",
+			Value: "32GiB",
+			Usage: "specify the sector size the pieces have to fit, 2KiB, 8MiB, 512MiB, 32GiB or 64GiB",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		ctx := context.Background()
-		targetPath := c.Args().First()
-
-		res, err := graphsplit.CalcCommP(ctx, targetPath, c.Bool("rename"), c.Bool("add-padding"))
+		if manifestPath := c.String("verify-manifest"); manifestPath != "" {
+			return verifyManifest(ctx, manifestPath, c.Int("parallel"), c.Int("commp-workers"))
+		}
+		sectorSize, err := graphsplit.ParseSectorSize(c.String("sector-size"))
+		if err != nil {
+			return err
+		}
+		padTo, err := graphsplit.ParsePadTo(c.String("pad-to"), sectorSize)
 		if err != nil {
 			return err
 		}
+		if pieceCid := c.String("convert"); pieceCid != "" {
+			return convertPieceCID(pieceCid, c.Int64("payload-size"), padTo)
+		}
+		if c.NArg() == 0 {
+			return fmt.Errorf("expect a CAR file, a directory or a glob")
+		}
 
-		fmt.Printf("PieceCID: %s, PieceSize: %d\n", res.Root, res.Size)
-		return nil
+		results, err := graphsplit.CalcCommPBatch(ctx, &graphsplit.CommPParams{
+			Paths:      c.Args().Slice(),
+			Parallel:   c.Int("parallel"),
+			SectorSize: sectorSize,
+			PieceParams: graphsplit.PieceParams{
+				Rename:       c.Bool("rename"),
+				AddPadding:   c.Bool("add-padding"),
+				CommPWorkers: c.Int("commp-workers"),
+				PadTo:        padTo,
+			},
+			SaveManifest:   c.Bool("save-manifest"),
+			ManifestDir:    c.String("manifest-dir"),
+			ManifestFormat: c.String("manifest-format"),
+		})
+		for _, res := range results {
+			switch {
+			case res.Err != nil:
+			case len(results) == 1:
+				fmt.Printf("PieceCID: %s, PieceSize: %d, PieceCIDv2: %s\n", res.Root, res.Size, res.RootV2)
+			default:
+				fmt.Printf("%s PieceCID: %s, PieceSize: %d, PieceCIDv2: %s\n", res.Path, res.Root, res.Size, res.RootV2)
+			}
+		}
+		return err
 	},
 }
 
+// convertPieceCID prints the piece CID v2 of a piece CID v1 or the piece CID v1 of a piece CID v2
+func convertPieceCID(s string, payloadSize, padTo int64) error {
+	pieceCid, err := cid.Decode(s)
+	if err != nil {
+		return fmt.Errorf("invalid piece CID %s: %w", s, err)
+	}
+	if graphsplit.IsPieceCIDV2(pieceCid) {
+		commP, payloadSize, pieceSize, err := graphsplit.PieceCIDV1(pieceCid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("PieceCID: %s, PieceSize: %d, PayloadSize: %d\n", commP, pieceSize, payloadSize)
+		return nil
+	}
+	if payloadSize <= 0 {
+		return fmt.Errorf("payload-size is required to convert a piece CID v1")
+	}
+	var pieceSize abi.UnpaddedPieceSize
+	if padTo != 0 {
+		pieceSize = abi.PaddedPieceSize(padTo).Unpadded()
+	}
+	commPV2, err := graphsplit.PieceCIDV2(pieceCid, payloadSize, pieceSize)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("PieceCIDv2: %s\n", commPV2)
+	return nil
+}
+
+func verifyManifest(ctx context.Context, manifestPath string, parallel, commPWorkers int) error {
+	results, err := graphsplit.VerifyManifest(ctx, manifestPath, parallel, commPWorkers)
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	for _, res := range results {
+		counts[res.Status]++
+		if res.OK() {
+			fmt.Printf("OK %s %s\n", res.PieceCid, res.File)
+			continue
+		}
+		fmt.Printf("%s %s %s\n", strings.ToUpper(res.Status), res.PieceCid, res.File)
+		for _, e := range res.Errors {
+			fmt.Println("  " + e)
+		}
+	}
+	fmt.Printf("%d pieces: %d ok, %d mismatched, %d with wrong sizes, %d missing\n", len(results),
+		counts[graphsplit.PieceOK], counts[graphsplit.PieceMismatch], counts[graphsplit.PieceSizeMismatch], counts[graphsplit.PieceMissing])
+	if failed := len(results) - counts[graphsplit.PieceOK]; failed > 0 {
+		return fmt.Errorf("%d of %d pieces do not match %s", failed, len(results), manifestPath)
+	}
+	return nil
+}
+
 var importDatasetCmd = &cli.Command{
 	Name:  "import-dataset",
 	Usage: "import files from the specified dataset",
@@ -299,3 +681,194 @@ var importDatasetCmd = &cli.Command{
 		return dataset.Import(ctx, targetPath, c.String("dsmongo"))
 	},
 }
+
+var locateCmd = &cli.Command{
+	Name:  "locate",
+	Usage: "List the pieces holding the matching files",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "car-dir",
+			Required: true,
+			Usage:    "specify the CAR directory containing the file catalog",
+		},
+	},
+	ArgsUsage: "<path-or-glob>",
+	Action: func(c *cli.Context) error {
+		carDir := c.String("car-dir")
+		pattern := c.Args().First()
+		if pattern == "" {
+			return fmt.Errorf("path or glob is required")
+		}
+		entries, err := graphsplit.Locate(carDir, pattern)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("no file matches %s", pattern)
+		}
+		var lastPath string
+		for _, e := range entries {
+			if e.SourcePath != lastPath {
+				fmt.Printf("%s (%d bytes)\n", e.SourcePath, e.SourceSize)
+				lastPath = e.SourcePath
+			}
+			pieceCid := e.PieceCid
+			if pieceCid == "" {
+				pieceCid = "-"
+			}
+			if e.IsSplitPart() {
+				fmt.Printf("  part bytes %d-%d", e.Offset, e.Offset+e.Length-1)
+			} else {
+				fmt.Printf("  whole file")
+			}
+			fmt.Printf(", piece: %s, payload: %s, slice: %s, car: %s, path: %s\n",
+				pieceCid, e.PayloadCid, e.SliceName, e.CarFile, e.DagPath)
+		}
+		return nil
+	},
+}
+
+var verifyCmd = &cli.Command{
+	Name:  "verify",
+	Usage: "Check the integrity of CAR files without restoring them",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "car-path",
+			Required: true,
+			Usage:    "specify source car path, directory or file",
+		},
+		&cli.StringFlag{
+			Name:  "manifest",
+			Usage: "check the payload sizes against this manifest, by default the manifest next to the CAR files when there is one",
+		},
+		&cli.IntFlag{
+			Name:  "parallel",
+			Value: 4,
+			Usage: "specify how many CAR files are verified at once",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		results, err := graphsplit.Verify(context.Background(), &graphsplit.VerifyParams{
+			CarPath:  c.String("car-path"),
+			Manifest: c.String("manifest"),
+			Parallel: c.Int("parallel"),
+		})
+		if err != nil {
+			return err
+		}
+		var failed, errs int
+		for _, res := range results {
+			if res.OK() {
+				fmt.Printf("PASS %s (%d blocks, payload %d bytes)\n", res.Car, res.Blocks, res.PayloadSize)
+				continue
+			}
+			failed++
+			errs += res.ErrorCount
+			fmt.Printf("FAIL %s (%d blocks, %d errors)\n", res.Car, res.Blocks, res.ErrorCount)
+			for _, e := range res.Errors {
+				fmt.Println("  " + e)
+			}
+			if more := res.ErrorCount - len(res.Errors); more > 0 {
+				fmt.Printf("  and %d more errors\n", more)
+			}
+		}
+		fmt.Printf("%d CAR files: %d passed, %d failed, %d errors\n", len(results), len(results)-failed, failed, errs)
+		if failed > 0 {
+			return fmt.Errorf("%d of %d CAR files failed verify", failed, len(results))
+		}
+		return nil
+	},
+}
+
+var extractFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "car-dir",
+		Required: true,
+		Usage:    "specify source car path, directory or file",
+	},
+	&cli.Int64Flag{
+		Name:  "offset",
+		Value: 0,
+		Usage: "specify the first byte of the file to read",
+	},
+	&cli.Int64Flag{
+		Name:  "length",
+		Value: -1,
+		Usage: "specify how many bytes to read, -1 reads up to the end of the file",
+	},
+}
+
+var catCmd = &cli.Command{
+	Name:      "cat",
+	Usage:     "Print a file from CAR files without restoring them",
+	Flags:     extractFlags,
+	ArgsUsage: "<dataset-path>",
+	Action: func(c *cli.Context) error {
+		ctx := context.Background()
+		dagPath := c.Args().First()
+		if dagPath == "" {
+			return fmt.Errorf("dataset path is required")
+		}
+		cs, err := graphsplit.OpenCarSet(c.String("car-dir"))
+		if err != nil {
+			return err
+		}
+		defer cs.Close()
+
+		return cs.Cat(ctx, dagPath, c.Int64("offset"), c.Int64("length"), os.Stdout)
+	},
+}
+
+var getCmd = &cli.Command{
+	Name:  "get",
+	Usage: "Extract a file from CAR files without restoring them",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "specify output file, default is the file name in current directory",
+		},
+	}, extractFlags...),
+	ArgsUsage: "<dataset-path>",
+	Action: func(c *cli.Context) error {
+		ctx := context.Background()
+		dagPath := c.Args().First()
+		if dagPath == "" {
+			return fmt.Errorf("dataset path is required")
+		}
+		output := c.String("output")
+		if output == "" {
+			output = filepath.Base(dagPath)
+		}
+		cs, err := graphsplit.OpenCarSet(c.String("car-dir"))
+		if err != nil {
+			return err
+		}
+		defer cs.Close()
+		// a missing path fails before anything is written
+		if _, err := cs.Size(ctx, dagPath); err != nil {
+			return err
+		}
+
+		// the file is written aside and renamed when complete, a failed read leaves output as it was
+		f, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".*.tmp")
+		if err != nil {
+			return err
+		}
+		err = f.Chmod(0o644)
+		if err == nil {
+			err = cs.Cat(ctx, dagPath, c.Int64("offset"), c.Int64("length"), f)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), output)
+		}
+		if err != nil {
+			os.Remove(f.Name()) //nolint:errcheck
+			return err
+		}
+		fmt.Printf("%s written to %s\n", dagPath, output)
+		return nil
+	},
+}
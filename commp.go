@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 
 	"github.com/filecoin-project/go-commp-utils/v2"
 	"github.com/filecoin-project/go-padreader"
@@ -16,24 +17,48 @@ import (
 )
 
 type CommPRet struct {
-	Root        cid.Cid
+	Root cid.Cid
+	// RootV2 is the piece CID v2 of Root, holding the payload size and the piece size
+	RootV2      cid.Cid
 	PayloadSize int64
 	Size        abi.UnpaddedPieceSize
 }
 
+func newCommPRet(commP cid.Cid, pieceSize abi.UnpaddedPieceSize, payloadSize int64) (*CommPRet, error) {
+	commPV2, err := PieceCIDV2(commP, payloadSize, pieceSize)
+	if err != nil {
+		return nil, err
+	}
+	return &CommPRet{
+		Root:        commP,
+		RootV2:      commPV2,
+		PayloadSize: payloadSize,
+		Size:        pieceSize,
+	}, nil
+}
+
+// PieceParams configures how the piece of a CAR file is computed
+type PieceParams struct {
+	// Rename names the file after its piece CID
+	Rename bool
+	// AddPadding pads the file with zeros to its piece size
+	AddPadding bool
+	// CommPWorkers compute the piece at once, all the cores when it is not positive
+	CommPWorkers int
+	// PadTo pads the file to this piece size instead, its piece is computed as padded, 0 for none
+	PadTo int64
+}
+
 // almost copy paste from https://github.com/filecoin-project/lotus/node/impl/client/client.go#L749-L770
 func CalcCommP(ctx context.Context, inpath string, rename, addPadding bool) (*CommPRet, error) {
-	dir, _ := path.Split(inpath)
-	// Hard-code the sector type to 32GiBV1_1, because:
-	// - ffiwrapper.GeneratePieceCIDFromFile requires a RegisteredSealProof
-	// - commP itself is sector-size independent, with rather low probability of that changing
-	//   ( note how the final rust call is identical for every RegSP type )
-	//   https://github.com/filecoin-project/rust-filecoin-proofs-api/blob/v5.0.0/src/seal.rs#L1040-L1050
-	//
-	// IF/WHEN this changes in the future we will have to be able to calculate
-	// "old style" commP, and thus will need to introduce a version switch or similar
-	arbitraryProofType := abi.RegisteredSealProof_StackedDrg32GiBV1_1
+	return CalcCommPWithParams(ctx, inpath, &PieceParams{Rename: rename, AddPadding: addPadding})
+}
 
+// CalcCommPWithParams is CalcCommP with the piece computed by params.CommPWorkers, and padded to
+// params.PadTo when it is set
+func CalcCommPWithParams(ctx context.Context, inpath string, params *PieceParams) (*CommPRet, error) {
+	rename, addPadding, padTo := params.Rename, params.AddPadding, params.PadTo
+	dir, _ := path.Split(inpath)
 	st, err := os.Stat(inpath)
 	if err != nil {
 		return nil, err
@@ -42,7 +67,6 @@ func CalcCommP(ctx context.Context, inpath string, rename, addPadding bool) (*Co
 	if st.IsDir() {
 		return nil, fmt.Errorf("path %s is dir", inpath)
 	}
-	payloadSize := st.Size()
 
 	rdr, err := os.OpenFile(inpath, os.O_RDWR, 0o644)
 	if err != nil {
@@ -54,25 +78,34 @@ func CalcCommP(ctx context.Context, inpath string, rename, addPadding bool) (*Co
 	if err != nil {
 		return nil, err
 	}
-	carSize := stat.Size()
 	// check that the data is a car file; if it's not, retrieval won't work
-	_, err = car.ReadHeader(bufio.NewReader(rdr))
+	// for CARv2 the commP covers the inner CARv1 payload only
+	payload, carSize, version, err := carPayload(rdr, stat.Size())
 	if err != nil {
-		return nil, fmt.Errorf("not a car file: %w", err)
+		return nil, err
 	}
-
-	if _, err := rdr.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("seek to start: %w", err)
+	addPadding = addPadding || padTo != 0
+	if version == CarVersion2 && addPadding {
+		return nil, fmt.Errorf("car(%s) is a CARv2 file, padding is only supported for CARv1", inpath)
+	}
+	payloadSize := carSize
+	if version != CarVersion2 {
+		// a piece file padded before ends with zeros, they are no part of the payload
+		if payloadSize, err = carV1PayloadSize(payload); err != nil {
+			return nil, fmt.Errorf("car(%s): failed to read the payload: %w", inpath, err)
+		}
 	}
 
-	pieceReader, pieceSize := padreader.New(rdr, uint64(carSize))
-	commP, err := commp.GeneratePieceCIDFromFile(arbitraryProofType, pieceReader, pieceSize)
+	commP, pieceSize, err := calcPieceCIDParallel(payload, carSize, CommPWorkers(params.CommPWorkers))
 	if err != nil {
-		return nil, fmt.Errorf("computing commP failed: %w", err)
+		return nil, err
 	}
 
-	if padreader.PaddedSize(uint64(payloadSize)) != pieceSize {
-		return nil, fmt.Errorf("assert car(%s) file to piece fail payload size(%d) piece size (%d)", inpath, payloadSize, pieceSize)
+	if padreader.PaddedSize(uint64(carSize)) != pieceSize {
+		return nil, fmt.Errorf("assert car(%s) file to piece fail payload size(%d) piece size (%d)", inpath, carSize, pieceSize)
+	}
+	if commP, pieceSize, err = padPieceCID(commP, pieceSize, padTo); err != nil {
+		return nil, fmt.Errorf("car(%s): %w", inpath, err)
 	}
 	if addPadding {
 		// make sure fd point to the end of file
@@ -80,9 +113,17 @@ func CalcCommP(ctx context.Context, inpath string, rename, addPadding bool) (*Co
 		if _, err := rdr.Seek(carSize, io.SeekStart); err != nil {
 			return nil, fmt.Errorf("seek to start: %w", err)
 		}
-		if err := PadCar(rdr, carSize); err != nil {
+		if err := padCarTo(rdr, carSize, pieceSize); err != nil {
 			return nil, fmt.Errorf("failed to pad car file: %w", err)
 		}
+		// the checksum of the CAR file does not hold for the piece file
+		if int64(pieceSize) != carSize {
+			if err := os.Remove(CarChecksumPath(inpath)); err == nil {
+				log.Warnf("%s is padded, its checksum %s is removed", inpath, CarChecksumPath(inpath))
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
 	}
 	if rename {
 		piecePath := path.Join(dir, commP.String())
@@ -90,17 +131,50 @@ func CalcCommP(ctx context.Context, inpath string, rename, addPadding bool) (*Co
 		if err != nil {
 			return nil, fmt.Errorf("rename car(%s) file to piece %w", inpath, err)
 		}
+		// restore finds the slice index and catalog entries of the piece by its new name
+		if err := renameCarSidecars(inpath, piecePath, commP.String()); err != nil {
+			return nil, fmt.Errorf("rename sidecars of car(%s) %w", inpath, err)
+		}
 	}
-	return &CommPRet{
-		Root:        commP,
-		Size:        pieceSize,
-		PayloadSize: payloadSize,
-	}, nil
+	return newCommPRet(commP, pieceSize, payloadSize)
 }
 
-func CalcCommPV2(buf *Buffer, addPadding bool) (*CommPRet, error) {
+// renameCarSidecars follows the rename of a CAR file to its piece CID: the slice index and the
+// checksum are renamed, the catalog updated, and the cached block index dropped
+func renameCarSidecars(carPath, piecePath, pieceCid string) error {
+	if err := os.Rename(SliceIndexPath(carPath), SliceIndexPath(piecePath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(carPath + carIndexSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := renameCarChecksum(carPath, piecePath); err != nil {
+		return err
+	}
+	return renameInCatalog(filepath.Dir(carPath), filepath.Base(carPath), filepath.Base(piecePath), pieceCid)
+}
+
+// calcPieceCID computes the piece CID of the size bytes of r, zero padded to the piece size
+func calcPieceCID(r io.Reader, size int64) (cid.Cid, abi.UnpaddedPieceSize, error) {
+	// Hard-code the sector type to 32GiBV1_1, because:
+	// - ffiwrapper.GeneratePieceCIDFromFile requires a RegisteredSealProof
+	// - commP itself is sector-size independent, with rather low probability of that changing
+	//   ( note how the final rust call is identical for every RegSP type )
+	//   https://github.com/filecoin-project/rust-filecoin-proofs-api/blob/v5.0.0/src/seal.rs#L1040-L1050
+	//
+	// IF/WHEN this changes in the future we will have to be able to calculate
+	// "old style" commP, and thus will need to introduce a version switch or similar
 	arbitraryProofType := abi.RegisteredSealProof_StackedDrg32GiBV1_1
 
+	pieceReader, pieceSize := padreader.New(r, uint64(size))
+	commP, err := commp.GeneratePieceCIDFromFile(arbitraryProofType, pieceReader, pieceSize)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("computing commP failed: %w", err)
+	}
+	return commP, pieceSize, nil
+}
+
+func CalcCommPV2(buf *Buffer, addPadding bool) (*CommPRet, error) {
 	// check that the data is a car file; if it's not, retrieval won't work
 	_, err := car.ReadHeader(bufio.NewReader(buf))
 	if err != nil {
@@ -109,10 +183,9 @@ func CalcCommPV2(buf *Buffer, addPadding bool) (*CommPRet, error) {
 	buf.SeekStart()
 
 	carSize := int64(buf.Len())
-	pieceReader, pieceSize := padreader.New(buf, uint64(carSize))
-	commP, err := commp.GeneratePieceCIDFromFile(arbitraryProofType, pieceReader, pieceSize)
+	commP, pieceSize, err := calcPieceCID(buf, carSize)
 	if err != nil {
-		return nil, fmt.Errorf("computing commP failed: %w", err)
+		return nil, err
 	}
 
 	if padreader.PaddedSize(uint64(carSize)) != pieceSize {
@@ -120,17 +193,13 @@ func CalcCommPV2(buf *Buffer, addPadding bool) (*CommPRet, error) {
 	}
 
 	if addPadding {
-		// make sure fd point to the end of file
-		// better to check within carv1.PadCar, for now is a workaround
-		buf.Seek(int(carSize))
+		// the padding is appended to the buffer, rewound first as Buffer drops the data it
+		// grows from once it is all read
+		buf.SeekStart()
 		if err := PadCar(buf, carSize); err != nil {
 			return nil, fmt.Errorf("failed to pad car file: %w", err)
 		}
 	}
 
-	return &CommPRet{
-		Root:        commP,
-		Size:        pieceSize,
-		PayloadSize: int64(carSize),
-	}, nil
+	return newCommPRet(commP, pieceSize, carSize)
 }
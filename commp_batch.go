@@ -0,0 +1,272 @@
+package graphsplit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ipld/go-car"
+)
+
+// CommPParams configures CalcCommPBatch
+type CommPParams struct {
+	// Paths are CAR files, directories of CAR files or glob patterns of CAR files
+	Paths    []string
+	Parallel int
+	// SectorSize is the sector size the pieces have to fit, the default sector size when 0
+	SectorSize int64
+	// PieceParams are applied to every file, as by CalcCommPWithParams
+	PieceParams
+	// SaveManifest writes every piece to the manifest of ManifestDir, or to the manifest next to
+	// the CAR file when ManifestDir is empty, once all the files are done
+	SaveManifest   bool
+	ManifestDir    string
+	ManifestFormat string
+}
+
+// CommPFileResult is the piece of a CAR file computed by CalcCommPBatch, Err tells why it failed
+type CommPFileResult struct {
+	// Path is the CAR file, PiecePath is where it is once renamed
+	Path       string
+	PiecePath  string
+	PayloadCid string
+	*CommPRet
+	Err error
+}
+
+// CalcCommPBatch computes the piece CIDs of the CAR files of params.Paths with params.Parallel
+// workers. A failing file does not stop the others, the results are in the order of the files
+// and the errors are returned joined.
+func CalcCommPBatch(ctx context.Context, params *CommPParams) ([]*CommPFileResult, error) {
+	if params.Parallel <= 0 {
+		return nil, fmt.Errorf("parallel has to be greater than 0")
+	}
+	if params.SaveManifest {
+		if err := CheckManifestFormat(params.ManifestFormat); err != nil {
+			return nil, err
+		}
+	}
+	if params.SectorSize == 0 {
+		params.SectorSize = DefaultSectorSize
+	}
+	if err := CheckSectorSize(params.SectorSize); err != nil {
+		return nil, err
+	}
+	if params.PadTo != 0 {
+		if err := CheckPadTo(params.PadTo, params.SectorSize); err != nil {
+			return nil, err
+		}
+	}
+	files, err := expandCarPaths(params.Paths)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no CAR file found in %s", strings.Join(params.Paths, ", "))
+	}
+
+	results := make([]*CommPFileResult, len(files))
+	var wg sync.WaitGroup
+	idxCh := make(chan int)
+	for i := 0; i < params.Parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idxCh {
+				res := calcFileCommP(ctx, files[i], params)
+				if res.Err != nil {
+					log.Errorf("%s: %s", files[i], res.Err)
+				} else {
+					log.Infof("%s: piece cid %s, piece cid v2 %s, piece size %d", files[i], res.Root, res.RootV2, res.Size)
+				}
+				results[i] = res
+			}
+		}()
+	}
+	for i := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		idxCh <- i
+	}
+	close(idxCh)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	if params.SaveManifest {
+		if err := saveCommPManifests(results, params); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, res := range results {
+		if res.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", res.Path, res.Err))
+		}
+	}
+	if len(errs) > 0 {
+		return results, fmt.Errorf("commP failed: %w", errors.Join(errs...))
+	}
+	return results, nil
+}
+
+// expandCarPaths returns the files of paths, sorted: the files of the directories, the sidecars
+// left aside, and the matches of the glob patterns
+func expandCarPaths(paths []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(fpath string) {
+		if !seen[fpath] {
+			seen[fpath] = true
+			files = append(files, fpath)
+		}
+	}
+	for _, p := range paths {
+		matches := []string{p}
+		isGlob := strings.ContainsAny(p, `*?[\`)
+		if isGlob {
+			var err error
+			if matches, err = filepath.Glob(p); err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", p, err)
+			}
+		}
+		for _, m := range matches {
+			err := filepath.Walk(m, func(fpath string, fi os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				// a file given by name is taken as is, even named like a sidecar
+				if fi.Mode().IsRegular() && (fpath == p || !isCarDirSidecar(fi.Name())) {
+					add(fpath)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func calcFileCommP(ctx context.Context, fpath string, params *CommPParams) *CommPFileResult {
+	res := &CommPFileResult{Path: fpath, PiecePath: fpath}
+	root, payloadSize, err := carRootAndPayloadSize(fpath)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.PayloadCid = root
+	// a piece too large for the sector is neither padded nor renamed
+	if res.Err = checkPieceFits(payloadSize, params.SectorSize); res.Err != nil {
+		return res
+	}
+	if res.CommPRet, res.Err = CalcCommPWithParams(ctx, fpath, &params.PieceParams); res.Err != nil {
+		return res
+	}
+	if params.Rename {
+		res.PiecePath = filepath.Join(filepath.Dir(fpath), res.Root.String())
+	}
+	return res
+}
+
+// carRootAndPayloadSize reads the single root of a CAR file and the size of its CARv1 payload,
+// without the CARv2 wrapper or the padding of a piece file
+func carRootAndPayloadSize(fpath string) (string, int64, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	payload, carSize, version, err := carPayload(f, fi.Size())
+	if err != nil {
+		return "", 0, err
+	}
+	header, err := car.ReadHeader(bufio.NewReader(payload))
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid CAR header: %w", err)
+	}
+	if len(header.Roots) != 1 {
+		return "", 0, fmt.Errorf("CAR has %d roots, expect 1 payload root", len(header.Roots))
+	}
+	if version == CarVersion2 {
+		return header.Roots[0].String(), carSize, nil
+	}
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	payloadSize, err := carV1PayloadSize(payload)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read the payload: %w", err)
+	}
+	return header.Roots[0].String(), payloadSize, nil
+}
+
+// saveCommPManifests writes the pieces to their manifests. The slice of a CAR file already in a
+// manifest gets its piece, the other CAR files are added, and the manifest is replaced at once.
+func saveCommPManifests(results []*CommPFileResult, params *CommPParams) error {
+	var dirs []string
+	byDir := make(map[string][]*CommPFileResult)
+	for _, res := range results {
+		if res.Err != nil {
+			continue
+		}
+		dir := params.ManifestDir
+		if dir == "" {
+			dir = filepath.Dir(res.PiecePath)
+		}
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], res)
+	}
+	for _, dir := range dirs {
+		manifestPath := filepath.Join(dir, ManifestFileName(params.ManifestFormat))
+		var entries []*ManifestEntry
+		if _, err := os.Stat(manifestPath); err == nil {
+			if entries, err = ReadManifest(manifestPath); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		byPayload := make(map[string]*ManifestEntry, len(entries))
+		for _, e := range entries {
+			if _, ok := byPayload[e.PayloadCid]; !ok {
+				byPayload[e.PayloadCid] = e
+			}
+		}
+		for _, res := range byDir[dir] {
+			e, ok := byPayload[res.PayloadCid]
+			if !ok {
+				e = &ManifestEntry{PayloadCid: res.PayloadCid, Filename: filepath.Base(res.Path), Files: []ManifestFile{}}
+				byPayload[res.PayloadCid] = e
+				entries = append(entries, e)
+			}
+			e.HasCommP = true
+			e.PieceCid = res.Root.String()
+			e.PieceCidV2 = res.RootV2.String()
+			e.PayloadSize = res.PayloadSize
+			e.PieceSize = uint64(res.Size)
+		}
+		if err := rewriteManifest(dir, params.ManifestFormat, entries); err != nil {
+			return fmt.Errorf("failed to write %s: %w", manifestPath, err)
+		}
+		log.Infof("%d pieces written to %s", len(byDir[dir]), manifestPath)
+	}
+	return nil
+}
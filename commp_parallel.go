@@ -0,0 +1,161 @@
+package graphsplit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/bits"
+	"runtime"
+	"sync"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commphash "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/filecoin-project/go-padreader"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+// minCommPSubtree is the smallest padded size of the subtrees of a piece hashed by different
+// workers, smaller pieces are hashed by a single worker
+var minCommPSubtree uint64 = 16 << 20
+
+// zeroCommitments holds the commitment of a zero piece of padded size 32<<i at i, it is the node
+// a piece is padded with at this level of its tree
+var zeroCommitments = func() [commphash.MaxLayers + 1][]byte {
+	var zero [commphash.MaxLayers + 1][]byte
+	zero[0] = make([]byte, 32)
+	for i := 1; i < len(zero); i++ {
+		zero[i] = commPNode(zero[i-1], zero[i-1])
+	}
+	return zero
+}()
+
+// commPNode is the parent of two nodes of the piece tree: their SHA-256 truncated to 254 bits
+func commPNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write(left)
+	h.Write(right)
+	out := h.Sum(nil)
+	out[31] &= 0x3F
+	return out
+}
+
+// CommPWorkers returns how many workers compute a piece for a requested count, all the cores when
+// it is not positive
+func CommPWorkers(workers int) int {
+	if workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return workers
+}
+
+// calcPieceCIDParallel computes the same piece CID as calcPieceCID with workers hashing subtrees
+// of the piece at once. The payload is split in subtrees of a power of two padded size, the
+// subtrees past the payload are zero and the last one is zero padded, their roots are combined
+// up to the root of the piece.
+func calcPieceCIDParallel(r io.ReaderAt, size int64, workers int) (cid.Cid, abi.UnpaddedPieceSize, error) {
+	pieceSize := padreader.PaddedSize(uint64(size))
+	padded := uint64(pieceSize.Padded())
+	subtree := padded
+	for subtree/2 >= minCommPSubtree && padded/subtree < 4*uint64(workers) {
+		subtree /= 2
+	}
+	if workers <= 1 || subtree == padded {
+		return calcPieceCID(io.NewSectionReader(r, 0, size), size)
+	}
+
+	unpaddedSubtree := int64(abi.PaddedPieceSize(subtree).Unpadded())
+	roots := make([][]byte, (size+unpaddedSubtree-1)/unpaddedSubtree)
+	errs := make([]error, len(roots))
+	var wg sync.WaitGroup
+	idxCh := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idxCh {
+				offset := int64(i) * unpaddedSubtree
+				length := min(unpaddedSubtree, size-offset)
+				roots[i], errs[i] = subtreeCommP(io.NewSectionReader(r, offset, length), length, subtree)
+			}
+		}()
+	}
+	for i := range roots {
+		idxCh <- i
+	}
+	close(idxCh)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return cid.Undef, 0, fmt.Errorf("computing commP failed: %w", err)
+		}
+	}
+
+	level := bits.TrailingZeros64(subtree / 32)
+	for nodes := roots; ; level++ {
+		if len(nodes) == 1 && uint64(32)<<level == padded {
+			commP, err := commcid.DataCommitmentV1ToCID(nodes[0])
+			if err != nil {
+				return cid.Undef, 0, err
+			}
+			return commP, pieceSize, nil
+		}
+		parents := make([][]byte, 0, (len(nodes)+1)/2)
+		for i := 0; i < len(nodes); i += 2 {
+			right := zeroCommitments[level]
+			if i+1 < len(nodes) {
+				right = nodes[i+1]
+			}
+			parents = append(parents, commPNode(nodes[i], right))
+		}
+		nodes = parents
+	}
+}
+
+// padPieceCID returns the piece of the payload of a piece zero padded to the piece size padTo,
+// the root of the piece is a leaf of the larger tree and the rest are zero subtrees. The piece is
+// returned as is when padTo is 0.
+func padPieceCID(commP cid.Cid, pieceSize abi.UnpaddedPieceSize, padTo int64) (cid.Cid, abi.UnpaddedPieceSize, error) {
+	padded := uint64(pieceSize.Padded())
+	if padTo == 0 || padded == uint64(padTo) {
+		return commP, pieceSize, nil
+	}
+	if padded > uint64(padTo) {
+		return cid.Undef, 0, fmt.Errorf("piece size %d is larger than the piece size %d to pad to", padded, padTo)
+	}
+	root, err := commcid.CIDToDataCommitmentV1(commP)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	for level := bits.TrailingZeros64(padded / 32); padded < uint64(padTo); level++ {
+		root = commPNode(root, zeroCommitments[level])
+		padded *= 2
+	}
+	if commP, err = commcid.DataCommitmentV1ToCID(root); err != nil {
+		return cid.Undef, 0, err
+	}
+	return commP, abi.PaddedPieceSize(padTo).Unpadded(), nil
+}
+
+// subtreeCommP computes the root of the subtree of padded size subtree holding the length bytes of r
+func subtreeCommP(r io.Reader, length int64, subtree uint64) ([]byte, error) {
+	cp := &commphash.Calc{}
+	if _, err := io.Copy(cp, r); err != nil {
+		cp.Reset()
+		return nil, err
+	}
+	// commP is not defined for less than 65 bytes, the zeros padding them change nothing
+	if length < int64(commphash.MinPiecePayload) {
+		cp.Write(bytes.Repeat([]byte{0}, int(commphash.MinPiecePayload)-int(length))) //nolint:errcheck
+	}
+	root, paddedSize, err := cp.Digest()
+	if err != nil {
+		return nil, err
+	}
+	for level := bits.TrailingZeros64(paddedSize / 32); paddedSize < subtree; level++ {
+		root = commPNode(root, zeroCommitments[level])
+		paddedSize *= 2
+	}
+	return root, nil
+}
@@ -0,0 +1,172 @@
+package graphsplit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commphash "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/filecoin-project/go-padreader"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+// pieceWriter writes a CAR file while computing the piece CID of its payload and the SHA-256 of
+// the file, so the CAR bytes are read once
+type pieceWriter struct {
+	w   io.Writer
+	cp  *commphash.Calc
+	sha hash.Hash
+	// the piece is made of the written bytes in [pieceStart, pieceEnd), the CARv1 payload
+	pieceStart, pieceEnd int64
+	n                    int64
+}
+
+func newPieceWriter(w io.Writer, pieceStart, pieceSize int64, calcCommP, withSHA256 bool) *pieceWriter {
+	pw := &pieceWriter{w: w, pieceStart: pieceStart, pieceEnd: pieceStart + pieceSize}
+	if calcCommP {
+		pw.cp = &commphash.Calc{}
+	}
+	if withSHA256 {
+		pw.sha = sha256.New()
+	}
+	return pw
+}
+
+func (pw *pieceWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	if pw.sha != nil {
+		pw.sha.Write(p[:n])
+	}
+	if pw.cp != nil {
+		lo, hi := max(pw.pieceStart-pw.n, 0), min(pw.pieceEnd-pw.n, int64(n))
+		if lo < hi {
+			if _, err := pw.cp.Write(p[lo:hi]); err != nil {
+				return n, err
+			}
+		}
+	}
+	pw.n += int64(n)
+	return n, err
+}
+
+// piece returns the piece CID and the piece size of the payload written
+func (pw *pieceWriter) piece() (cid.Cid, abi.UnpaddedPieceSize, error) {
+	commP, paddedSize, err := pw.cp.Digest()
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("computing commP failed: %w", err)
+	}
+	c, err := commcid.DataCommitmentV1ToCID(commP)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	return c, abi.PaddedPieceSize(paddedSize).Unpadded(), nil
+}
+
+// abort stops the workers of the commP computation when the piece is not needed anymore
+func (pw *pieceWriter) abort() {
+	if pw.cp != nil {
+		pw.cp.Reset()
+	}
+}
+
+// writeCarFile writes the CAR of buf to fpath in the CAR version, zero padded to its piece size
+// when addPadding is set, or to the piece size padTo when it is not 0. A CARv2 is never padded, it
+// fails with padTo. The piece of the CARv1 payload is computed on the way when calcCommP is set, so
+// is the SHA-256 of the file with withSHA256, empty otherwise. With several commP workers the piece
+// is rather computed from buf by the workers while the file is written.
+func writeCarFile(fpath string, buf *Buffer, version int, addPadding, calcCommP, withSHA256 bool, commPWorkers int, padTo int64) (*CommPRet, string, error) {
+	carSize := int64(buf.Len())
+	pieceSize := padreader.PaddedSize(uint64(carSize))
+	if padTo != 0 {
+		if version == CarVersion2 {
+			return nil, "", fmt.Errorf("padding to a piece size is only supported for CARv1, the piece of a CARv2 is its inner CARv1 payload")
+		}
+		if int64(pieceSize.Padded()) > padTo {
+			return nil, "", fmt.Errorf("car of %d bytes is larger than the piece size %d to pad to", carSize, padTo)
+		}
+		addPadding, pieceSize = true, abi.PaddedPieceSize(padTo).Unpadded()
+	}
+
+	f, err := os.Create(fpath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	bw := bufio.NewWriterSize(f, 1<<20)
+
+	var pieceStart int64
+	if version == CarVersion2 {
+		pieceStart = carv2.PragmaSize + carv2.HeaderSize
+	}
+	type pieceRet struct {
+		commP     cid.Cid
+		pieceSize abi.UnpaddedPieceSize
+		err       error
+	}
+	var parallelPiece chan pieceRet
+	if calcCommP && commPWorkers > 1 {
+		// the bytes of buf stay in place while the CAR is written from it
+		data := buf.Bytes()
+		parallelPiece = make(chan pieceRet, 1)
+		go func() {
+			var ret pieceRet
+			ret.commP, ret.pieceSize, ret.err = calcPieceCIDParallel(bytes.NewReader(data), carSize, commPWorkers)
+			parallelPiece <- ret
+		}()
+		calcCommP = false
+	}
+	pw := newPieceWriter(bw, pieceStart, carSize, calcCommP, withSHA256)
+	err = writeCar(pw, buf, version)
+	if err == nil && addPadding && version != CarVersion2 {
+		err = padCarTo(pw, carSize, pieceSize)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	var piece pieceRet
+	if parallelPiece != nil {
+		piece = <-parallelPiece
+	}
+	if err != nil {
+		pw.abort()
+		return nil, "", fmt.Errorf("failed to write car file: %w", err)
+	}
+
+	var sum string
+	if pw.sha != nil {
+		sum = hex.EncodeToString(pw.sha.Sum(nil))
+	}
+	switch {
+	case parallelPiece != nil:
+	case calcCommP:
+		piece.commP, piece.pieceSize, piece.err = pw.piece()
+	default:
+		return nil, sum, nil
+	}
+	if piece.err != nil {
+		return nil, "", piece.err
+	}
+	if padreader.PaddedSize(uint64(carSize)) != piece.pieceSize {
+		return nil, "", fmt.Errorf("assert file to piece fail payload size(%d) piece size (%d)", carSize, piece.pieceSize)
+	}
+	commP, pieceSize, err := padPieceCID(piece.commP, piece.pieceSize, padTo)
+	if err != nil {
+		return nil, "", err
+	}
+	cpRes, err := newCommPRet(commP, pieceSize, carSize)
+	if err != nil {
+		return nil, "", err
+	}
+	return cpRes, sum, nil
+}
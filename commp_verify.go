@@ -0,0 +1,214 @@
+package graphsplit
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+// status of a piece checked by VerifyManifest
+const (
+	PieceOK       = "ok"
+	PieceMissing  = "missing"
+	PieceMismatch = "mismatch"
+	// PieceSizeMismatch is a piece whose CID matches the manifest but not its recorded sizes
+	PieceSizeMismatch = "size"
+)
+
+// PieceCheck is the result of checking a slice of a manifest against its CAR or piece file
+type PieceCheck struct {
+	PayloadCid string `json:"payload_cid"`
+	PieceCid   string `json:"piece_cid"`
+	// File is the CAR or piece file of the slice, empty when it is missing
+	File        string   `json:"file,omitempty"`
+	Status      string   `json:"status"`
+	Actual      string   `json:"actual,omitempty"`
+	PayloadSize int64    `json:"payload_size,omitempty"`
+	PieceSize   uint64   `json:"piece_size,omitempty"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+func (pc *PieceCheck) OK() bool {
+	return pc.Status == PieceOK
+}
+
+func (pc *PieceCheck) fail(status, format string, args ...any) {
+	// a wrong piece CID outweighs a wrong size
+	if pc.Status != PieceMismatch {
+		pc.Status = status
+	}
+	pc.Errors = append(pc.Errors, fmt.Sprintf(format, args...))
+}
+
+// VerifyManifest recomputes the piece CID of every slice of the manifest with a piece CID, from the
+// CAR or piece file next to the manifest, and compares it with the recorded piece CID, payload size
+// and piece size. The results are in the order of the manifest. The piece of every file is computed
+// by commPWorkers at once, all the cores when it is not positive.
+func VerifyManifest(ctx context.Context, manifestPath string, parallel, commPWorkers int) ([]*PieceCheck, error) {
+	if parallel <= 0 {
+		return nil, fmt.Errorf("parallel has to be greater than 0")
+	}
+	commPWorkers = CommPWorkers(commPWorkers)
+	entries, err := ReadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var pieces []*ManifestEntry
+	for _, e := range entries {
+		if e.HasCommP && e.PieceCid != "" {
+			pieces = append(pieces, e)
+		}
+	}
+	if len(pieces) == 0 {
+		return nil, fmt.Errorf("no piece CID in %s", manifestPath)
+	}
+
+	dir := filepath.Dir(manifestPath)
+	results := make([]*PieceCheck, len(pieces))
+	var wg sync.WaitGroup
+	idxCh := make(chan int)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idxCh {
+				results[i] = checkPiece(dir, pieces[i], commPWorkers)
+				if results[i].OK() {
+					log.Infof("%s matches %s", results[i].File, results[i].PieceCid)
+				} else {
+					log.Errorf("piece %s: %s", results[i].PieceCid, results[i].Status)
+				}
+			}
+		}()
+	}
+	for i := range pieces {
+		if ctx.Err() != nil {
+			break
+		}
+		idxCh <- i
+	}
+	close(idxCh)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// findPieceFile returns the file of the slice in dir: renamed to its piece CID, or still a CAR file
+func findPieceFile(dir string, e *ManifestEntry) string {
+	for _, name := range []string{e.PieceCid, e.PieceCid + ".car", e.PayloadCid + ".car"} {
+		fpath := filepath.Join(dir, name)
+		if fi, err := os.Stat(fpath); err == nil && fi.Mode().IsRegular() {
+			return fpath
+		}
+	}
+	return ""
+}
+
+func checkPiece(dir string, e *ManifestEntry, commPWorkers int) *PieceCheck {
+	pc := &PieceCheck{PayloadCid: e.PayloadCid, PieceCid: e.PieceCid, Status: PieceOK}
+	if pc.File = findPieceFile(dir, e); pc.File == "" {
+		pc.fail(PieceMissing, "no CAR or piece file for %s in %s", e.PieceCid, dir)
+		return pc
+	}
+	f, err := os.Open(pc.File)
+	if err != nil {
+		pc.fail(PieceMissing, "%s", err)
+		return pc
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		pc.fail(PieceMissing, "%s", err)
+		return pc
+	}
+	payload, carSize, version, err := carPayload(f, fi.Size())
+	if err != nil {
+		pc.fail(PieceMismatch, "%s", err)
+		return pc
+	}
+	pc.PayloadSize = carSize
+	if version != CarVersion2 {
+		// a padded piece file ends with zeros after the CARv1 payload
+		if pc.PayloadSize, err = carV1PayloadSize(payload); err != nil {
+			pc.fail(PieceMismatch, "failed to read the payload: %s", err)
+			return pc
+		}
+	}
+
+	commP, pieceSize, err := calcPieceCIDParallel(payload, carSize, commPWorkers)
+	if err != nil {
+		pc.fail(PieceMismatch, "%s", err)
+		return pc
+	}
+	pc.Actual = commP.String()
+	pc.PieceSize = uint64(pieceSize)
+	if pc.Actual != e.PieceCid {
+		pc.fail(PieceMismatch, "piece CID is %s, the manifest records %s", pc.Actual, e.PieceCid)
+	}
+	if pc.PayloadSize != e.PayloadSize {
+		pc.fail(PieceSizeMismatch, "payload size is %d, the manifest records %d", pc.PayloadSize, e.PayloadSize)
+	}
+	if pc.PieceSize != e.PieceSize {
+		pc.fail(PieceSizeMismatch, "piece size is %d, the manifest records %d", pc.PieceSize, e.PieceSize)
+	}
+	if e.PieceCidV2 != "" {
+		pc.checkV2(e.PieceCidV2, commP, pieceSize)
+	}
+	if carSize != pc.PayloadSize && carSize != int64(pieceSize) {
+		pc.fail(PieceSizeMismatch, "file is padded to %d bytes, the piece holds %d", carSize, pieceSize)
+	}
+	return pc
+}
+
+// checkV2 compares the piece CID v2 of the manifest with the one of the piece, a piece CID v2 of the
+// piece with other sizes is a size mismatch
+func (pc *PieceCheck) checkV2(recorded string, commP cid.Cid, pieceSize abi.UnpaddedPieceSize) {
+	actual, err := PieceCIDV2(commP, pc.PayloadSize, pieceSize)
+	if err != nil {
+		pc.fail(PieceMismatch, "%s", err)
+		return
+	}
+	if actual.String() == recorded {
+		return
+	}
+	status := PieceMismatch
+	if v2, err := cid.Decode(recorded); err == nil {
+		if v1, _, _, err := PieceCIDV1(v2); err == nil && v1.Equals(commP) {
+			status = PieceSizeMismatch
+		}
+	}
+	pc.fail(status, "piece CID v2 is %s, the manifest records %s", actual, recorded)
+}
+
+// carV1PayloadSize reads the sections of a CARv1 up to the end or to the zero padding of a piece
+// file, it returns the size of the CARv1 payload
+func carV1PayloadSize(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	for {
+		next, err := br.Peek(1)
+		if err == io.EOF || err == nil && next[0] == 0 {
+			return cr.n - int64(br.Buffered()), nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		l, err := binary.ReadUvarint(br)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := br.Discard(int(l)); err != nil {
+			return 0, fmt.Errorf("section truncated after %d of %d bytes: %w", n, l, err)
+		}
+	}
+}
@@ -14,6 +14,7 @@ type Config struct {
 	SliceSize               int    `toml:"SliceSize" comment:"SliceSize, the size of each slice in bytes, default is 18G"`
 	ExtraFilePath           string `toml:"ExtraFilePath" comment:"ExtraFilePath extra file path, 指向存储了图片、视频等文件的目录"`
 	ExtraFileSizeInOnePiece string `toml:"ExtraFileSizeInOnePiece" comment:"ExtraFileSizeInOnePiece 每个 piece 文件包含图片和视频等文件的大小, 例如：500Mib"`
+	SectorSize              string `toml:"SectorSize" comment:"SectorSize, the sector size the pieces are made for, 2KiB, 8MiB, 512MiB, 32GiB or 64GiB, default is 32GiB"`
 }
 
 func NewConfig() *Config {
@@ -21,6 +22,7 @@ func NewConfig() *Config {
 		SliceSize:               19327352832, // 18G
 		ExtraFileSizeInOnePiece: "",
 		ExtraFilePath:           "",
+		SectorSize:              "32GiB",
 	}
 }
 
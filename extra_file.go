@@ -13,10 +13,40 @@ type ExtraFile struct {
 	idx          int
 	sliceSize    int64
 	pieceRawSize int64
+	sectorSize   int64
 }
 
+// ExtraFileParams configures NewExtraFileWithParams
+type ExtraFileParams struct {
+	Path                   string
+	SliceSize              int64
+	PieceRawSize           int64
+	RandomRenameSourceFile bool
+	// SectorSize is the sector size the pieces have to fit, the default sector size when 0
+	SectorSize int64
+}
+
+// NewExtraFile adds up to sliceSize bytes of the files of path to every slice, as long as the piece
+// still fits a sector of the default sector size
 func NewExtraFile(path string, sliceSize int64, pieceRawSize int64, randomRenameSourceFile bool) (*ExtraFile, error) {
-	rf := &ExtraFile{path: path, sliceSize: sliceSize, pieceRawSize: pieceRawSize}
+	return NewExtraFileWithParams(&ExtraFileParams{
+		Path:                   path,
+		SliceSize:              sliceSize,
+		PieceRawSize:           pieceRawSize,
+		RandomRenameSourceFile: randomRenameSourceFile,
+	})
+}
+
+// NewExtraFileWithParams is NewExtraFile with the pieces fitting a sector of params.SectorSize
+func NewExtraFileWithParams(params *ExtraFileParams) (*ExtraFile, error) {
+	path, sectorSize := params.Path, params.SectorSize
+	if sectorSize == 0 {
+		sectorSize = DefaultSectorSize
+	}
+	if err := CheckSectorSize(sectorSize); err != nil {
+		return nil, err
+	}
+	rf := &ExtraFile{path: path, sliceSize: params.SliceSize, pieceRawSize: params.PieceRawSize, sectorSize: sectorSize}
 	if path != "" {
 		finfo, err := os.Stat(path)
 		if err != nil {
@@ -25,7 +55,7 @@ func NewExtraFile(path string, sliceSize int64, pieceRawSize int64, randomRename
 		if !finfo.IsDir() {
 			return nil, fmt.Errorf("the path %s is not a directory", path)
 		}
-		rf.walk(randomRenameSourceFile)
+		rf.walk(params.RandomRenameSourceFile)
 	}
 
 	return rf, nil
@@ -52,7 +82,7 @@ func (rf *ExtraFile) getFiles() []Finfo {
 	startIdx := rf.idx
 	for total < rf.sliceSize {
 		file := rf.files[rf.idx]
-		if total+file.Info.Size()+rf.pieceRawSize <= 32*Gib {
+		if total+file.Info.Size()+rf.pieceRawSize <= SectorCapacity(rf.sectorSize) {
 			total += file.Info.Size()
 			files = append(files, file)
 		}
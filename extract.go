@@ -0,0 +1,348 @@
+package graphsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	uio "github.com/ipfs/go-unixfs/io"
+)
+
+// CarSet reads files out of a set of CAR files without restoring them. CARs are opened
+// lazily and only the blocks on the path to the requested file are read.
+type CarSet struct {
+	cars []*carSetEntry
+}
+
+type carSetEntry struct {
+	path string
+	// indexPath is where the index of a CARv1 is cached, it is not cached when empty
+	indexPath string
+	index     *SliceIndex
+	bs        *CarBlockstore
+	dserv     ipld.DAGService
+}
+
+// filePart is a whole file, or one of the parts of a split file, found in a CAR
+type filePart struct {
+	idx  int
+	car  *carSetEntry
+	node ipld.Node
+	size int64
+}
+
+// OpenCarSet collects the CAR files of carPath, a CAR file or a directory
+func OpenCarSet(carPath string) (*CarSet, error) {
+	cs := &CarSet{}
+	err := filepath.Walk(carPath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || isCarDirSidecar(fi.Name()) {
+			return nil
+		}
+		entry := &carSetEntry{path: path, indexPath: path + carIndexSuffix}
+		if index, err := ReadSliceIndex(path); err == nil {
+			entry.index = index
+		}
+		cs.cars = append(cs.cars, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(cs.cars) == 0 {
+		return nil, fmt.Errorf("no CAR file found in %s", carPath)
+	}
+	return cs, nil
+}
+
+func (cs *CarSet) Close() error {
+	for _, car := range cs.cars {
+		if car.bs != nil {
+			car.bs.Close() //nolint:errcheck
+			car.bs = nil
+		}
+	}
+	return nil
+}
+
+// Size returns the size of the file at dagPath, adding up all its parts when it was split
+func (cs *CarSet) Size(ctx context.Context, dagPath string) (int64, error) {
+	parts, err := cs.findFile(ctx, dagPath)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, p := range parts {
+		total += p.size
+	}
+	return total, nil
+}
+
+// Cat writes length bytes of the file at dagPath starting at offset to w, a negative length
+// reads up to the end of the file. Parts of a split file are stitched from whichever CAR holds them.
+func (cs *CarSet) Cat(ctx context.Context, dagPath string, offset, length int64, w io.Writer) error {
+	parts, err := cs.findFile(ctx, dagPath)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, p := range parts {
+		total += p.size
+	}
+	if offset < 0 || offset > total {
+		return fmt.Errorf("offset %d out of range, %s has %d bytes", offset, dagPath, total)
+	}
+	if length < 0 || offset+length > total {
+		length = total - offset
+	}
+
+	var partStart int64
+	for _, p := range parts {
+		partEnd := partStart + p.size
+		if length > 0 && offset < partEnd {
+			n := partEnd - offset
+			if n > length {
+				n = length
+			}
+			if err := p.copyRange(ctx, w, offset-partStart, n); err != nil {
+				return fmt.Errorf("failed to read %s from %s: %w", dagPath, p.car.path, err)
+			}
+			offset += n
+			length -= n
+		}
+		partStart = partEnd
+	}
+	return nil
+}
+
+func (p *filePart) copyRange(ctx context.Context, w io.Writer, offset, length int64) error {
+	dr, err := uio.NewDagReader(ctx, p.node, p.car.dserv)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	if _, err := dr.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.CopyN(w, dr, length)
+	return err
+}
+
+// findFile looks up the file at dagPath, or the parts it was split into, in every CAR of the set
+func (cs *CarSet) findFile(ctx context.Context, dagPath string) ([]*filePart, error) {
+	dagPath = cleanDagPath(dagPath)
+	if dagPath == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	var whole *filePart
+	parts := make(map[int]*filePart)
+	for _, car := range cs.cars {
+		found, err := car.findFile(ctx, dagPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range found {
+			if p.idx < 0 {
+				if whole == nil {
+					whole = p
+				}
+			} else if _, ok := parts[p.idx]; !ok {
+				parts[p.idx] = p
+			}
+		}
+		if whole != nil {
+			return []*filePart{whole}, nil
+		}
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%s not found in any CAR file", dagPath)
+	}
+
+	idxs := make([]int, 0, len(parts))
+	for idx := range parts {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	res := make([]*filePart, 0, len(idxs))
+	for i, idx := range idxs {
+		if idx != i {
+			return nil, fmt.Errorf("part %s of %s is missing", splitPartName(dagPath, i), dagPath)
+		}
+		res = append(res, parts[idx])
+	}
+	return res, nil
+}
+
+func (car *carSetEntry) open() error {
+	if car.bs != nil {
+		return nil
+	}
+	bs, err := openCarBlockstore(car.path, car.indexPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", car.path, err)
+	}
+	car.bs = bs
+	car.dserv = bs.DAGService()
+	return nil
+}
+
+func (car *carSetEntry) findFile(ctx context.Context, dagPath string) ([]*filePart, error) {
+	dir, base := path.Split(dagPath)
+	dir = strings.TrimSuffix(dir, "/")
+	// the sidecar tells without opening the CAR whether it holds the file
+	if car.index != nil && !car.index.hasFile(dir, base) {
+		return nil, nil
+	}
+	if err := car.open(); err != nil {
+		return nil, err
+	}
+	roots, err := carRoots(ctx, car.path, car.bs.Roots(), car.dserv)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []*filePart
+	for _, root := range roots {
+		// a root restored to its own entry holds the paths under its CID
+		switch {
+		case root.dagPath == "":
+			found, err := car.findFileIn(ctx, root.node, dir, base)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, found...)
+		case dagPath == root.dagPath:
+			size, ok := unixfsFileSize(root.node)
+			if !ok {
+				return nil, fmt.Errorf("%s is a directory", dagPath)
+			}
+			parts = append(parts, &filePart{idx: -1, car: car, node: root.node, size: size})
+		case strings.HasPrefix(dir+"/", root.dagPath+"/"):
+			found, err := car.findFileIn(ctx, root.node, strings.TrimPrefix(strings.TrimPrefix(dir, root.dagPath), "/"), base)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, found...)
+		}
+	}
+	return parts, nil
+}
+
+// findFileIn looks up base, or its split parts, in the directory dir below the root nd
+func (car *carSetEntry) findFileIn(ctx context.Context, nd ipld.Node, dir, base string) ([]*filePart, error) {
+	var err error
+	if dir != "" {
+		for _, name := range strings.Split(dir, "/") {
+			nd, err = findChild(ctx, car.dserv, nd, name)
+			if err != nil {
+				return nil, err
+			}
+			if nd == nil {
+				return nil, nil
+			}
+		}
+	}
+	links, err := listDir(ctx, car.dserv, nd)
+	if err != nil || links == nil {
+		return nil, err
+	}
+
+	var parts []*filePart
+	for _, ln := range links {
+		idx, ok := matchSplitPart(ln.Name, base)
+		if !ok {
+			continue
+		}
+		child, err := ln.GetNode(ctx, car.dserv)
+		if err != nil {
+			return nil, err
+		}
+		// raw leaves are files too
+		size, ok := unixfsFileSize(child)
+		if !ok {
+			return nil, fmt.Errorf("%s is a directory or not a unixfs file", path.Join(dir, ln.Name))
+		}
+		parts = append(parts, &filePart{idx: idx, car: car, node: child, size: size})
+	}
+	return parts, nil
+}
+
+// findChild returns the entry name of the directory nd, or nil when nd is not a directory or has no such entry
+func findChild(ctx context.Context, dserv ipld.DAGService, nd ipld.Node, name string) (ipld.Node, error) {
+	dir, err := uio.NewDirectoryFromNode(dserv, nd)
+	if err != nil {
+		return nil, nil
+	}
+	child, err := dir.Find(ctx, name)
+	if err == os.ErrNotExist || ipld.IsNotFound(err) {
+		return nil, nil
+	}
+	return child, err
+}
+
+// listDir returns the entries of the directory nd, or nil when nd is not a directory
+func listDir(ctx context.Context, dserv ipld.DAGService, nd ipld.Node) ([]*ipld.Link, error) {
+	dir, err := uio.NewDirectoryFromNode(dserv, nd)
+	if err != nil {
+		return nil, nil
+	}
+	return dir.Links(ctx)
+}
+
+func splitPartName(name string, idx int) string {
+	return fmt.Sprintf("%s.%08d", name, idx)
+}
+
+// matchSplitPart reports whether name is the file base, index -1, or one of its split parts
+func matchSplitPart(name, base string) (int, bool) {
+	if name == base {
+		return -1, true
+	}
+	suffix, ok := strings.CutPrefix(name, base+".")
+	if !ok || len(suffix) != 8 {
+		return 0, false
+	}
+	idx, err := strconv.Atoi(suffix)
+	if err != nil || idx < 0 {
+		return 0, false
+	}
+	return idx, true
+}
+
+// hasFile reports whether the directory dir of the slice holds base or one of its split parts
+func (idx *SliceIndex) hasFile(dir, base string) bool {
+	nd := idx.Root
+	if nd == nil {
+		return true
+	}
+	if dir != "" {
+		for _, name := range strings.Split(dir, "/") {
+			var next *fsNode
+			for i := range nd.Link {
+				if nd.Link[i].Name == name {
+					next = &nd.Link[i]
+					break
+				}
+			}
+			if next == nil {
+				return false
+			}
+			nd = next
+		}
+	}
+	for _, ln := range nd.Link {
+		if _, ok := matchSplitPart(ln.Name, base); ok {
+			return true
+		}
+	}
+	return false
+}
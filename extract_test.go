@@ -0,0 +1,103 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chunkTestData writes a small dataset with a file larger than the slice size
+// and chunks it into carDir with sha256 checksums, it returns the dataset directory
+func chunkTestData(t *testing.T, carDir string, sliceSize int64) string {
+	t.Helper()
+	return chunkTestDataWith(t, carDir, sliceSize, ChecksumSHA256, nil)
+}
+
+// chunkTestDataWith is chunkTestData with another checksum algorithm, and another callback than
+// the CSV manifest when cb is set
+func chunkTestDataWith(t *testing.T, carDir string, sliceSize int64, checksum string, cb GraphBuildCallback) string {
+	t.Helper()
+	dataDir := filepath.Join(t.TempDir(), "data")
+	files := map[string][]byte{
+		"a/big.bin":  make([]byte, 3*sliceSize+123),
+		"a/b/x.txt":  []byte("hello"),
+		"y.txt":      []byte("world"),
+		"c/empty.md": {},
+	}
+	rand.New(rand.NewSource(1)).Read(files["a/big.bin"])
+	for name, data := range files {
+		p := filepath.Join(dataDir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if cb == nil {
+		cb = CSVCallback(carDir)
+	}
+	ef, err := NewExtraFile("", 0, sliceSize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Chunk(context.Background(), &ChunkParams{
+		ExpectSliceSize: sliceSize,
+		TargetPath:      dataDir,
+		CarDir:          carDir,
+		GraphName:       "test",
+		Parallel:        2,
+		Cb:              cb,
+		Ef:              ef,
+		Checksum:        checksum,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dataDir
+}
+
+func TestCarSetCat(t *testing.T) {
+	carDir := t.TempDir()
+	dataDir := chunkTestData(t, carDir, 64<<10)
+	big, err := os.ReadFile(filepath.Join(dataDir, "a/big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := OpenCarSet(carDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+
+	ctx := context.Background()
+	cases := []struct {
+		path           string
+		offset, length int64
+		expect         []byte
+	}{
+		{"a/big.bin", 0, -1, big},
+		{"a/big.bin", 60 << 10, 100 << 10, big[60<<10 : 160<<10]},
+		{"a/big.bin", int64(len(big)) - 10, 100, big[len(big)-10:]},
+		{"/a/b/x.txt", 0, -1, []byte("hello")},
+		{"y.txt", 1, 3, []byte("orl")},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := cs.Cat(ctx, c.path, c.offset, c.length, &buf); err != nil {
+			t.Fatalf("cat %s: %v", c.path, err)
+		}
+		if !bytes.Equal(buf.Bytes(), c.expect) {
+			t.Fatalf("cat %s at %d: unexpected content", c.path, c.offset)
+		}
+	}
+
+	if err := cs.Cat(ctx, "a/missing", 0, -1, &bytes.Buffer{}); err == nil {
+		t.Fatal("expect an error for a missing file")
+	}
+}
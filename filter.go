@@ -0,0 +1,152 @@
+package graphsplit
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PathFilter selects paths inside the graph with include and exclude globs. A pattern
+// matching a directory selects everything under it, a path is selected when it matches
+// one of the include patterns, or there is none, and none of the exclude patterns.
+// A pattern without a slash matches a name at any depth, like *.tif. Parts of a split
+// file are matched by the name of the original file.
+type PathFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// NewPathFilter checks the patterns, it returns nil when there is nothing to filter
+func NewPathFilter(include, exclude []string) (*PathFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	f := &PathFilter{}
+	for _, patterns := range []struct {
+		src []string
+		dst *[]string
+	}{{include, &f.Include}, {exclude, &f.Exclude}} {
+		for _, p := range patterns.src {
+			p = cleanDagPath(p)
+			if _, err := path.Match(p, ""); err != nil || p == "" {
+				return nil, fmt.Errorf("invalid path pattern %q", p)
+			}
+			*patterns.dst = append(*patterns.dst, p)
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether the file at dagPath is selected
+func (f *PathFilter) Match(dagPath string) bool {
+	if f == nil {
+		return true
+	}
+	dagPath = cleanDagPath(dagPath)
+	if len(f.Include) > 0 && !matchAnyPattern(f.Include, dagPath) {
+		return false
+	}
+	return !matchAnyPattern(f.Exclude, dagPath)
+}
+
+// MayContain reports whether the directory at dagPath can hold selected files
+func (f *PathFilter) MayContain(dagPath string) bool {
+	if f == nil {
+		return true
+	}
+	dagPath = cleanDagPath(dagPath)
+	if dagPath == "" {
+		return true
+	}
+	if matchAnyPattern(f.Exclude, dagPath) {
+		return false
+	}
+	if len(f.Include) == 0 || matchAnyPattern(f.Include, dagPath) {
+		return true
+	}
+	names := strings.Split(dagPath, "/")
+	for _, pattern := range f.Include {
+		if !strings.Contains(pattern, "/") {
+			return true
+		}
+		segs := strings.Split(pattern, "/")
+		if len(segs) <= len(names) {
+			continue
+		}
+		prefix := true
+		for i, name := range names {
+			if ok, _ := path.Match(segs[i], name); !ok {
+				prefix = false
+				break
+			}
+		}
+		if prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchIndex reports whether the slice holds at least one selected file
+func (f *PathFilter) MatchIndex(index *SliceIndex) bool {
+	if f == nil || index.Root == nil {
+		return true
+	}
+	var walk func(nd *fsNode, dagPath string) bool
+	walk = func(nd *fsNode, dagPath string) bool {
+		if len(nd.Link) == 0 {
+			return dagPath != "" && f.Match(dagPath)
+		}
+		if !f.MayContain(dagPath) {
+			return false
+		}
+		for i := range nd.Link {
+			if walk(&nd.Link[i], path.Join(dagPath, nd.Link[i].Name)) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(index.Root, "")
+}
+
+// matchAnyPattern reports whether p, or one of its parent directories, matches one of the patterns
+func matchAnyPattern(patterns []string, p string) bool {
+	if len(patterns) == 0 || p == "" {
+		return false
+	}
+	candidates := []string{p}
+	if base := trimSplitPart(p); base != p {
+		candidates = append(candidates, base)
+	}
+	for _, c := range candidates {
+		for ; c != "." && c != "/" && c != ""; c = path.Dir(c) {
+			for _, pattern := range patterns {
+				name := c
+				if !strings.Contains(pattern, "/") {
+					name = path.Base(c)
+				}
+				if ok, _ := path.Match(pattern, name); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// trimSplitPart returns the name of the original file of a split part, or name itself
+func trimSplitPart(name string) string {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 || i == 0 || name[i-1] == '/' {
+		return name
+	}
+	if _, ok := matchSplitPart(name, name[:i]); ok {
+		return name[:i]
+	}
+	return name
+}
+
+func cleanDagPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
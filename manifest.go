@@ -0,0 +1,480 @@
+package graphsplit
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	ManifestFormatCSV    = "csv"
+	ManifestFormatJSONL  = "jsonl"
+	ManifestFormatSQLite = "sqlite"
+)
+
+// ManifestFileName returns the name of the manifest file for the given format
+func ManifestFileName(format string) string {
+	switch format {
+	case ManifestFormatJSONL:
+		return "manifest.jsonl"
+	case ManifestFormatSQLite:
+		return "manifest.db"
+	default:
+		return "manifest.csv"
+	}
+}
+
+// IsManifestFile reports whether name is one of the manifest files written to car-dir
+func IsManifestFile(name string) bool {
+	for _, format := range []string{ManifestFormatCSV, ManifestFormatJSONL, ManifestFormatSQLite} {
+		if strings.HasPrefix(name, ManifestFileName(format)) {
+			return true
+		}
+	}
+	return false
+}
+
+func CheckManifestFormat(format string) error {
+	switch format {
+	case ManifestFormatCSV, ManifestFormatJSONL, ManifestFormatSQLite:
+		return nil
+	}
+	return fmt.Errorf("unsupported manifest format %q, expect one of csv, jsonl, sqlite", format)
+}
+
+// ManifestFile is an entry of the file list of a graph slice
+type ManifestFile struct {
+	Path string `json:"path"`
+}
+
+// ManifestEntry describes a graph slice, one per line of manifest.csv
+type ManifestEntry struct {
+	PayloadCid  string         `json:"payload_cid"`
+	Filename    string         `json:"filename"`
+	PieceCid    string         `json:"piece_cid,omitempty"`
+	PieceCidV2  string         `json:"piece_cid_v2,omitempty"`
+	PayloadSize int64          `json:"payload_size,omitempty"`
+	PieceSize   uint64         `json:"piece_size,omitempty"`
+	Files       []ManifestFile `json:"files"`
+
+	// Detail is the raw json of the file list, written to the detail column of manifest.csv
+	Detail string `json:"-"`
+	// HasCommP reports whether PieceCid, PayloadSize and PieceSize are set, PieceCidV2 is empty in
+	// the manifests written before it
+	HasCommP bool `json:"-"`
+}
+
+func newManifestEntry(payloadCid, filename, fsDetail string) (*ManifestEntry, error) {
+	var infos []SimplestFileInfo
+	if fsDetail != "" {
+		if err := json.Unmarshal([]byte(fsDetail), &infos); err != nil {
+			return nil, fmt.Errorf("failed to parse slice detail: %w", err)
+		}
+	}
+	files := make([]ManifestFile, 0, len(infos))
+	for _, info := range infos {
+		files = append(files, ManifestFile{Path: info.Path})
+	}
+	return &ManifestEntry{
+		PayloadCid: payloadCid,
+		Filename:   filename,
+		Files:      files,
+		Detail:     fsDetail,
+	}, nil
+}
+
+// AppendManifest adds a slice entry to the manifest of carDir, creating the manifest if necessary
+func AppendManifest(carDir, format string, entry *ManifestEntry) error {
+	return appendManifestEntries(path.Join(carDir, ManifestFileName(format)), format, []*ManifestEntry{entry})
+}
+
+func appendManifestEntries(manifestPath, format string, entries []*ManifestEntry) error {
+	switch format {
+	case ManifestFormatCSV, "":
+		return appendCSVManifest(manifestPath, entries)
+	case ManifestFormatJSONL:
+		for _, entry := range entries {
+			if err := appendJSONLManifest(manifestPath, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ManifestFormatSQLite:
+		for _, entry := range entries {
+			if err := appendSQLiteManifest(manifestPath, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return CheckManifestFormat(format)
+	}
+}
+
+// rewriteManifest replaces the manifest of carDir with entries
+func rewriteManifest(carDir, format string, entries []*ManifestEntry) error {
+	manifestPath := path.Join(carDir, ManifestFileName(format))
+	tmpPath := manifestPath + ".tmp"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := appendManifestEntries(tmpPath, format, entries); err != nil {
+		os.Remove(tmpPath) //nolint:errcheck
+		return err
+	}
+	return os.Rename(tmpPath, manifestPath)
+}
+
+// the columns of manifest.csv, with commP or without
+var (
+	csvManifestColumns      = []string{"payload_cid", "filename", "detail"}
+	csvCommPManifestColumns = []string{"payload_cid", "filename", "piece_cid", "payload_size", "piece_size", "detail", "piece_cid_v2"}
+)
+
+// csvManifestHeader reads the columns of an existing manifest.csv, nil when there is none
+func csvManifestHeader(manifestPath string) ([]string, error) {
+	f, err := os.Open(manifestPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	header, err := csv.NewReader(f).Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", manifestPath, err)
+	}
+	return header, nil
+}
+
+func appendCSVManifest(manifestPath string, entries []*ManifestEntry) error {
+	// the columns of an existing manifest are kept, a new one has the commP columns when an entry has a piece
+	header, err := csvManifestHeader(manifestPath)
+	if err != nil {
+		return err
+	}
+	exists := header != nil
+	hasCommP := slices.Contains(header, "piece_cid")
+	for _, entry := range entries {
+		if entry.HasCommP && !hasCommP {
+			if exists {
+				return fmt.Errorf("%s has no piece_cid column", manifestPath)
+			}
+			hasCommP = true
+		}
+	}
+	f, err := os.OpenFile(manifestPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	csvWriter := csv.NewWriter(f)
+	csvWriter.UseCRLF = true
+	if !exists {
+		header = csvManifestColumns
+		if hasCommP {
+			header = csvCommPManifestColumns
+		}
+		if err := csvWriter.Write(header); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		// a slice without a piece has empty commP columns
+		values := map[string]string{"payload_cid": entry.PayloadCid, "filename": entry.Filename, "detail": entry.Detail}
+		if entry.HasCommP {
+			values["piece_cid"] = entry.PieceCid
+			values["piece_cid_v2"] = entry.PieceCidV2
+			values["payload_size"] = strconv.FormatInt(entry.PayloadSize, 10)
+			values["piece_size"] = strconv.FormatUint(entry.PieceSize, 10)
+		}
+		record := make([]string, len(header))
+		for i, name := range header {
+			record[i] = values[name]
+		}
+		if err := csvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func appendJSONLManifest(manifestPath string, entry *ManifestEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(manifestPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+const manifestSchema = `
+CREATE TABLE IF NOT EXISTS slices (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	payload_cid  TEXT NOT NULL,
+	filename     TEXT NOT NULL,
+	piece_cid    TEXT,
+	payload_size INTEGER,
+	piece_size   INTEGER,
+	piece_cid_v2 TEXT
+);
+CREATE INDEX IF NOT EXISTS slices_payload_cid ON slices (payload_cid);
+CREATE INDEX IF NOT EXISTS slices_piece_cid ON slices (piece_cid);
+CREATE INDEX IF NOT EXISTS slices_filename ON slices (filename);
+CREATE TABLE IF NOT EXISTS files (
+	slice_id INTEGER NOT NULL REFERENCES slices (id),
+	path     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS files_slice_id ON files (slice_id);
+CREATE INDEX IF NOT EXISTS files_path ON files (path);
+`
+
+func openSQLite(dbPath, schema string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	// chunk and restore write from several goroutines, let sqlite serialize them
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA busy_timeout = 10000"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to init %s: %w", dbPath, err)
+	}
+	return db, nil
+}
+
+// openManifestDB opens manifest.db, the piece_cid_v2 column is added to the manifests written before it
+func openManifestDB(manifestPath string) (*sql.DB, error) {
+	db, err := openSQLite(manifestPath, manifestSchema)
+	if err != nil {
+		return nil, err
+	}
+	var hasV2 bool
+	if err := db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info('slices') WHERE name = 'piece_cid_v2'").Scan(&hasV2); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if !hasV2 {
+		if _, err := db.Exec("ALTER TABLE slices ADD COLUMN piece_cid_v2 TEXT"); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to upgrade %s: %w", manifestPath, err)
+		}
+	}
+	return db, nil
+}
+
+func appendSQLiteManifest(manifestPath string, entry *ManifestEntry) error {
+	db, err := openManifestDB(manifestPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var pieceCid, pieceCidV2, payloadSize, pieceSize any
+	if entry.HasCommP {
+		pieceCid, payloadSize, pieceSize = entry.PieceCid, entry.PayloadSize, int64(entry.PieceSize)
+		if entry.PieceCidV2 != "" {
+			pieceCidV2 = entry.PieceCidV2
+		}
+	}
+	res, err := tx.Exec("INSERT INTO slices (payload_cid, filename, piece_cid, payload_size, piece_size, piece_cid_v2) VALUES (?, ?, ?, ?, ?, ?)",
+		entry.PayloadCid, entry.Filename, pieceCid, payloadSize, pieceSize, pieceCidV2)
+	if err != nil {
+		return err
+	}
+	sliceID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO files (slice_id, path) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, f := range entry.Files {
+		if _, err := stmt.Exec(sliceID, f.Path); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ReadManifest reads the slice entries of a manifest written by chunk, the format is told by its name
+func ReadManifest(manifestPath string) ([]*ManifestEntry, error) {
+	switch name := path.Base(manifestPath); {
+	case strings.HasSuffix(name, ".jsonl"):
+		return readJSONLManifest(manifestPath)
+	case strings.HasSuffix(name, ".db"):
+		return readSQLiteManifest(manifestPath)
+	default:
+		return readCSVManifest(manifestPath)
+	}
+}
+
+// FindManifest returns the manifest chunk wrote to carDir, empty when there is none
+func FindManifest(carDir string) string {
+	for _, format := range []string{ManifestFormatCSV, ManifestFormatJSONL, ManifestFormatSQLite} {
+		manifestPath := path.Join(carDir, ManifestFileName(format))
+		if fi, err := os.Stat(manifestPath); err == nil && fi.Mode().IsRegular() {
+			return manifestPath
+		}
+	}
+	return ""
+}
+
+func readCSVManifest(manifestPath string) ([]*ManifestEntry, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", manifestPath, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	// the columns depend on whether commP was calculated
+	col := make(map[string]int)
+	for i, name := range records[0] {
+		col[name] = i
+	}
+	for _, name := range []string{"payload_cid", "filename", "detail"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("%s has no %s column", manifestPath, name)
+		}
+	}
+	_, hasCommP := col["piece_cid"]
+	entries := make([]*ManifestEntry, 0, len(records)-1)
+	for i, record := range records[1:] {
+		entry, err := newManifestEntry(record[col["payload_cid"]], record[col["filename"]], record[col["detail"]])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", manifestPath, i+2, err)
+		}
+		// a slice without a piece has empty commP columns
+		if hasCommP && record[col["piece_cid"]] != "" {
+			entry.HasCommP = true
+			entry.PieceCid = record[col["piece_cid"]]
+			if v2, ok := col["piece_cid_v2"]; ok {
+				entry.PieceCidV2 = record[v2]
+			}
+			if entry.PayloadSize, err = strconv.ParseInt(record[col["payload_size"]], 10, 64); err != nil {
+				return nil, fmt.Errorf("%s line %d: invalid payload_size: %w", manifestPath, i+2, err)
+			}
+			if entry.PieceSize, err = strconv.ParseUint(record[col["piece_size"]], 10, 64); err != nil {
+				return nil, fmt.Errorf("%s line %d: invalid piece_size: %w", manifestPath, i+2, err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func readJSONLManifest(manifestPath string) ([]*ManifestEntry, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*ManifestEntry
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		entry := &ManifestEntry{}
+		if err := dec.Decode(entry); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", manifestPath, err)
+		}
+		entry.HasCommP = entry.PieceCid != ""
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func readSQLiteManifest(manifestPath string) ([]*ManifestEntry, error) {
+	if _, err := os.Stat(manifestPath); err != nil {
+		return nil, err
+	}
+	db, err := openManifestDB(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, payload_cid, filename, piece_cid, payload_size, piece_size, piece_cid_v2 FROM slices ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []*ManifestEntry
+	byID := make(map[int64]*ManifestEntry)
+	for rows.Next() {
+		var id int64
+		var pieceCid, pieceCidV2 sql.NullString
+		var payloadSize, pieceSize sql.NullInt64
+		entry := &ManifestEntry{Files: []ManifestFile{}}
+		if err := rows.Scan(&id, &entry.PayloadCid, &entry.Filename, &pieceCid, &payloadSize, &pieceSize, &pieceCidV2); err != nil {
+			return nil, err
+		}
+		if pieceCid.Valid {
+			entry.HasCommP = true
+			entry.PieceCid, entry.PayloadSize, entry.PieceSize = pieceCid.String, payloadSize.Int64, uint64(pieceSize.Int64)
+			entry.PieceCidV2 = pieceCidV2.String
+		}
+		entries = append(entries, entry)
+		byID[id] = entry
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	files, err := db.Query("SELECT slice_id, path FROM files ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer files.Close()
+	for files.Next() {
+		var id int64
+		var f ManifestFile
+		if err := files.Scan(&id, &f.Path); err != nil {
+			return nil, err
+		}
+		if entry, ok := byID[id]; ok {
+			entry.Files = append(entry.Files, f)
+		}
+	}
+	return entries, files.Err()
+}
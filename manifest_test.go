@@ -0,0 +1,144 @@
+package graphsplit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadCSVManifestLayouts(t *testing.T) {
+	detail := `[{"Name":"a.txt","Path":"data/a.txt","Size":5}]`
+	quoted := `"` + strings.ReplaceAll(detail, `"`, `""`) + `"`
+	layouts := map[string]string{
+		// the layout of the manifests written before the piece CID v2
+		"old": "payload_cid,filename,piece_cid,payload_size,piece_size,detail\r\n" +
+			"bafy1,a.car,baga1,100,127," + quoted + "\r\n",
+		"new": "payload_cid,filename,piece_cid,payload_size,piece_size,detail,piece_cid_v2\r\n" +
+			"bafy1,a.car,baga1,100,127," + quoted + ",bafkz1\r\n",
+	}
+	for name, content := range layouts {
+		manifestPath := filepath.Join(t.TempDir(), "manifest.csv")
+		if err := os.WriteFile(manifestPath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// a slice appended to an existing manifest follows its columns
+		err := appendCSVManifest(manifestPath, []*ManifestEntry{{
+			PayloadCid: "bafy2", Filename: "b.car", Detail: detail,
+			HasCommP: true, PieceCid: "baga2", PieceCidV2: "bafkz2", PayloadSize: 200, PieceSize: 254,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := ReadManifest(manifestPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("%s layout: expect 2 entries, got %d", name, len(entries))
+		}
+		for i, e := range entries {
+			v2 := []string{"bafkz1", "bafkz2"}[i]
+			if name == "old" {
+				v2 = ""
+			}
+			if !e.HasCommP || e.PieceCid != []string{"baga1", "baga2"}[i] || e.PieceCidV2 != v2 ||
+				e.PayloadSize != []int64{100, 200}[i] || e.PieceSize != []uint64{127, 254}[i] ||
+				len(e.Files) != 1 || e.Files[0].Path != "data/a.txt" {
+				t.Fatalf("%s layout: unexpected entry %d %+v", name, i, e)
+			}
+		}
+		data, err := os.ReadFile(manifestPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(data), layouts[name]) {
+			t.Fatalf("%s layout: the existing lines changed:\n%s", name, data)
+		}
+	}
+	// a new manifest has the piece CID v2 after the columns of the old layout
+	manifestPath := filepath.Join(t.TempDir(), "manifest.csv")
+	if err := appendCSVManifest(manifestPath, []*ManifestEntry{{PayloadCid: "bafy1", Filename: "a.car", HasCommP: true}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header, _, _ := strings.Cut(string(data), "\r\n"); header != strings.Join(csvCommPManifestColumns, ",") ||
+		!strings.HasPrefix(layouts["new"], header) {
+		t.Fatalf("unexpected header %q", header)
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	newEntry := func(i int, hasCommP bool) *ManifestEntry {
+		name := []string{"a", "b", "c"}[i]
+		detail := `[{"Name":"` + name + `.txt","Path":"data/` + name + `.txt","Size":5},{"Name":"x","Path":"data/x","Size":1}]`
+		entry, err := newManifestEntry("bafy-"+name, name+".car", detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hasCommP {
+			entry.HasCommP = true
+			entry.PieceCid, entry.PieceCidV2 = "baga-"+name, "bafkz-"+name
+			entry.PayloadSize, entry.PieceSize = int64(100*(i+1)), uint64(127<<i)
+		}
+		return entry
+	}
+	check := func(format string, got, expect []*ManifestEntry) {
+		t.Helper()
+		if len(got) != len(expect) {
+			t.Fatalf("%s: read %d entries, expect %d", format, len(got), len(expect))
+		}
+		for i, e := range expect {
+			g := got[i]
+			if g.PayloadCid != e.PayloadCid || g.Filename != e.Filename || g.HasCommP != e.HasCommP ||
+				g.PieceCid != e.PieceCid || g.PieceCidV2 != e.PieceCidV2 || g.PayloadSize != e.PayloadSize ||
+				g.PieceSize != e.PieceSize || len(g.Files) != len(e.Files) {
+				t.Fatalf("%s: entry %d is %+v, expect %+v", format, i, g, e)
+			}
+			for j := range e.Files {
+				if g.Files[j] != e.Files[j] {
+					t.Fatalf("%s: file %d of entry %d is %s, expect %s", format, j, i, g.Files[j].Path, e.Files[j].Path)
+				}
+			}
+		}
+	}
+
+	for _, format := range []string{ManifestFormatCSV, ManifestFormatJSONL, ManifestFormatSQLite} {
+		for _, hasCommP := range []bool{false, true} {
+			carDir := t.TempDir()
+			var written []*ManifestEntry
+			// every entry after the first is appended to the existing manifest
+			for i := 0; i < 3; i++ {
+				entry := newEntry(i, hasCommP)
+				if err := AppendManifest(carDir, format, entry); err != nil {
+					t.Fatal(err)
+				}
+				written = append(written, entry)
+
+				manifestPath := FindManifest(carDir)
+				if manifestPath != filepath.Join(carDir, ManifestFileName(format)) {
+					t.Fatalf("%s: unexpected manifest %q", format, manifestPath)
+				}
+				entries, err := ReadManifest(manifestPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				check(format, entries, written)
+			}
+
+			// a rewritten manifest holds the new entries only
+			rewritten := []*ManifestEntry{written[2], written[0]}
+			if err := rewriteManifest(carDir, format, rewritten); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := ReadManifest(filepath.Join(carDir, ManifestFileName(format)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(format, entries, rewritten)
+		}
+	}
+}
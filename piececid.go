@@ -0,0 +1,82 @@
+package graphsplit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-padreader"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// fr32Sha256Trunc254Padbintree is the multihash of a piece CID v2 (FRC-0069), its digest holds the
+// padding of the payload, the height of the piece tree and its root
+const fr32Sha256Trunc254Padbintree = 0x1011
+
+// IsPieceCIDV2 reports whether c is a piece CID v2
+func IsPieceCIDV2(c cid.Cid) bool {
+	return c.Defined() && c.Type() == cid.Raw && c.Prefix().MhType == fr32Sha256Trunc254Padbintree
+}
+
+// PieceCIDV2 converts the piece CID v1 of a payload of payloadSize bytes to the piece CID v2. The
+// piece size is the one of the payload when 0, it is larger for a payload padded to a piece size.
+func PieceCIDV2(commP cid.Cid, payloadSize int64, pieceSize abi.UnpaddedPieceSize) (cid.Cid, error) {
+	root, err := commcid.CIDToDataCommitmentV1(commP)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("%s is no piece CID v1: %w", commP, err)
+	}
+	if payloadSize <= 0 {
+		return cid.Undef, fmt.Errorf("invalid payload size %d", payloadSize)
+	}
+	if pieceSize == 0 {
+		pieceSize = padreader.PaddedSize(uint64(payloadSize))
+	}
+	if err := pieceSize.Validate(); err != nil {
+		return cid.Undef, err
+	}
+	if int64(pieceSize) < payloadSize {
+		return cid.Undef, fmt.Errorf("payload size %d is larger than the piece size %d", payloadSize, pieceSize)
+	}
+
+	height := bits.TrailingZeros64(uint64(pieceSize.Padded()) / 32)
+	digest := binary.AppendUvarint(nil, uint64(int64(pieceSize)-payloadSize))
+	digest = append(digest, byte(height))
+	digest = append(digest, root...)
+	mh, err := multihash.Encode(digest, fr32Sha256Trunc254Padbintree)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(cid.Raw, mh), nil
+}
+
+// PieceCIDV1 converts a piece CID v2 to the piece CID v1, it returns the payload size and the
+// piece size the piece CID v2 holds along
+func PieceCIDV1(c cid.Cid) (cid.Cid, int64, abi.UnpaddedPieceSize, error) {
+	if !IsPieceCIDV2(c) {
+		return cid.Undef, 0, 0, fmt.Errorf("%s is no piece CID v2", c)
+	}
+	decoded, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return cid.Undef, 0, 0, err
+	}
+	padding, n := binary.Uvarint(decoded.Digest)
+	if n <= 0 || len(decoded.Digest) != n+1+32 {
+		return cid.Undef, 0, 0, fmt.Errorf("invalid piece CID v2 %s", c)
+	}
+	height := int(decoded.Digest[n])
+	if height < 2 || height > 58 {
+		return cid.Undef, 0, 0, fmt.Errorf("invalid tree height %d of piece CID v2 %s", height, c)
+	}
+	pieceSize := abi.PaddedPieceSize(32 << height).Unpadded()
+	if padding >= uint64(pieceSize) {
+		return cid.Undef, 0, 0, fmt.Errorf("invalid padding %d of piece CID v2 %s", padding, c)
+	}
+	commP, err := commcid.DataCommitmentV1ToCID(decoded.Digest[n+1:])
+	if err != nil {
+		return cid.Undef, 0, 0, err
+	}
+	return commP, int64(pieceSize) - int64(padding), pieceSize, nil
+}
@@ -2,90 +2,279 @@ package graphsplit
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
-	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
-	"github.com/ipfs/go-datastore"
-	dss "github.com/ipfs/go-datastore/sync"
-	bstore "github.com/ipfs/go-ipfs-blockstore"
-	offline "github.com/ipfs/go-ipfs-exchange-offline"
 	files "github.com/ipfs/go-libipfs/files"
-	"github.com/ipfs/go-merkledag"
 	unixfile "github.com/ipfs/go-unixfs/file"
 	"github.com/ipld/go-car"
+	carv2 "github.com/ipld/go-car/v2"
 )
 
+// Import loads the blocks of a CAR file into st. Both CARv1 and CARv2 are accepted, along with
+// padded piece files, reading stops at the zero padding after the CARv1 payload.
 func Import(ctx context.Context, path string, st car.Store) (cid.Cid, error) {
-	f, err := os.Open(path)
+	roots, err := ImportRoots(ctx, path, st)
 	if err != nil {
 		return cid.Undef, err
 	}
-	defer f.Close() //nolint:errcheck
-
-	stat, err := f.Stat()
-	if err != nil {
-		return cid.Undef, err
+	if len(roots) != 1 {
+		return cid.Undef, fmt.Errorf("cannot import car with %d roots, use ImportRoots", len(roots))
 	}
+	return roots[0], nil
+}
 
-	file, err := files.NewReaderPathFile(path, f, stat)
+// ImportRoots loads the blocks of a CAR file into st like Import, and returns all its roots
+func ImportRoots(ctx context.Context, path string, st car.Store) ([]cid.Cid, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return cid.Undef, err
+		return nil, err
 	}
+	defer f.Close() //nolint:errcheck
 
-	result, err := car.LoadCar(ctx, st, file)
+	br, err := carv2.NewBlockReader(f, carv2.ZeroLengthSectionAsEOF(true))
 	if err != nil {
-		return cid.Undef, err
+		return nil, fmt.Errorf("not a car file: %w", err)
 	}
-
-	if len(result.Roots) != 1 {
-		return cid.Undef, fmt.Errorf("cannot import car with more than one root")
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := st.Put(ctx, blk); err != nil {
+			return nil, err
+		}
 	}
-
-	return result.Roots[0], nil
+	return br.Roots, nil
 }
 
+// NodeWriteTo writes nd to fpath, entry names and symlink targets that would lead out of fpath are rejected
 func NodeWriteTo(nd files.Node, fpath string) error {
+	r := &restorer{params: &RestoreParams{OutputDir: fpath}}
+	return r.writeNode(nd, fpath, "")
+}
+
+// restorer writes the files of CAR files to the output directory
+type restorer struct {
+	params  *RestoreParams
+	plan    restorePlan
+	usage   restoreUsage
+	entries restoreEntries
+	state   *restoreState
+	indexes *carIndexCache
+	// carDirs are the directories of the CAR files, their catalogs tell which slices hold the parts of split files
+	carDirs []string
+
+	mu        sync.Mutex
+	failures  []*RestoreFailure
+	abortOnce sync.Once
+	abortErr  error
+	cancel    context.CancelFunc
+	// written maps the path inside the graph of every whole file written to where it is written,
+	// it is only kept to verify the checksums
+	written map[string]string
+}
+
+// abort stops all the restore workers
+func (r *restorer) abort(err error) {
+	r.abortOnce.Do(func() {
+		r.mu.Lock()
+		r.abortErr = err
+		r.mu.Unlock()
+		if r.cancel != nil {
+			r.cancel()
+		}
+	})
+}
+
+// writeNode writes nd to fpath, only the entries selected by the filter are written
+func (r *restorer) writeNode(nd files.Node, fpath, dagPath string) error {
+	filter, dryRun := r.params.Filter, r.params.DryRun
 	switch nd := nd.(type) {
 	case *files.Symlink:
+		if !filter.Match(dagPath) {
+			return nil
+		}
+		if err := checkSymlinkTarget(dagPath, nd.Target); err != nil {
+			return err
+		}
+		if err := r.addEntry(dagPath); err != nil {
+			return err
+		}
+		fpath, err := r.resolveConflict(fpath, dagPath, entrySymlink, 0)
+		if err != nil || fpath == "" || dryRun {
+			return err
+		}
+		if err := r.checkParents(fpath); err != nil {
+			return err
+		}
+		if err := mkParentDir(fpath, filter); err != nil {
+			return err
+		}
 		return os.Symlink(nd.Target, fpath)
 	case files.File:
+		if !filter.Match(dagPath) {
+			return nil
+		}
+		if err := r.addEntry(dagPath); err != nil {
+			return err
+		}
+		size, err := nd.Size()
+		if err != nil {
+			return err
+		}
+		if sf, part, _ := r.plan.part(dagPath); sf != nil {
+			if sf.skip {
+				return nil
+			}
+			if part != nil && part.offset >= 0 {
+				if !dryRun {
+					if err := r.writeSplitPart(nd, sf.fpath, part); err != nil {
+						return err
+					}
+				}
+				part.done = true
+				return nil
+			}
+			log.Warnf("offset of %s is unknown as previous parts are missing, write it as is", dagPath)
+		}
+		fpath, err := r.resolveConflict(fpath, dagPath, entryFile, size)
+		if err != nil || fpath == "" {
+			return err
+		}
+		if dryRun {
+			return r.addBytes(size)
+		}
+		if err := r.checkParents(fpath); err != nil {
+			return err
+		}
+		if err := mkParentDir(fpath, filter); err != nil {
+			return err
+		}
 		f, err := os.Create(fpath)
 		if err != nil {
 			return err
 		}
 		defer f.Close()
-		_, err = io.Copy(f, nd)
+		_, err = io.Copy(&limitWriter{w: f, r: r}, nd)
 		if err != nil {
+			if errors.Is(err, ErrRestoreLimit) {
+				os.Remove(fpath)
+			}
 			return err
 		}
+		r.setWritten(dagPath, fpath)
 		return nil
 	case files.Directory:
-		if !ExistDir(fpath) {
-			err := os.Mkdir(fpath, 0o777)
-			if err != nil && os.IsNotExist(err) {
+		if !filter.MayContain(dagPath) {
+			return nil
+		}
+		if err := r.addEntry(dagPath); err != nil {
+			return err
+		}
+		if dagPath != "" {
+			var err error
+			if fpath, err = r.resolveConflict(fpath, dagPath, entryDir, 0); err != nil || fpath == "" {
 				return err
 			}
 		}
+		// with a filter, parent directories are created along with the first selected entry
+		if !dryRun && !ExistDir(fpath) {
+			if err := r.checkParents(fpath); err != nil {
+				return err
+			}
+			if filter == nil {
+				err := os.Mkdir(fpath, 0o777)
+				if err != nil && os.IsNotExist(err) {
+					return err
+				}
+			} else if filter.Match(dagPath) {
+				if err := os.MkdirAll(fpath, 0o777); err != nil {
+					return err
+				}
+			}
+		}
 
+		// with KeepGoing, the failures of the entries are joined once the directory is done
+		var errs []error
 		entries := nd.Entries()
 		for entries.Next() {
+			if err := checkEntryName(entries.Name()); err != nil {
+				return withPath(dagPath, err)
+			}
 			child := filepath.Join(fpath, entries.Name())
-			if err := NodeWriteTo(entries.Node(), child); err != nil {
-				return err
+			childPath := path.Join(dagPath, entries.Name())
+			if err := r.writeNode(entries.Node(), child, childPath); err != nil {
+				err = withPath(childPath, err)
+				if !r.params.KeepGoing || abortsRestore(err) {
+					return err
+				}
+				errs = append(errs, err)
 			}
 		}
-		return entries.Err()
+		if err := entries.Err(); err != nil {
+			errs = append(errs, withPath(dagPath, err))
+		}
+		return errors.Join(errs...)
 	default:
 		return fmt.Errorf("file type %T at %q is not supported", nd, fpath)
 	}
 }
 
+// writeSplitPart writes a part of a split file at its offset in the pre-created file fpath
+func (r *restorer) writeSplitPart(nd files.File, fpath string, part *splitPart) error {
+	if err := checkNoSymlinkIn(r.params.OutputDir, fpath); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fpath, os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(&limitWriter{w: io.NewOffsetWriter(f, part.offset), r: r}, nd)
+	if err != nil {
+		return err
+	}
+	if n != part.size {
+		return fmt.Errorf("part of %s has %d bytes, expect %d", fpath, n, part.size)
+	}
+	return f.Close()
+}
+
+// checkParents checks that no parent directory of fpath in the output directory is a symlink, so
+// that nothing is created out of it through a directory replaced by a symlink
+func (r *restorer) checkParents(fpath string) error {
+	if filepath.Clean(fpath) == filepath.Clean(r.params.OutputDir) {
+		return nil
+	}
+	return checkNoSymlinkIn(r.params.OutputDir, filepath.Dir(fpath))
+}
+
+func mkParentDir(fpath string, filter *PathFilter) error {
+	if filter == nil {
+		return nil
+	}
+	return os.MkdirAll(filepath.Dir(fpath), 0o777)
+}
+
+// isCarDirSidecar reports whether name is one of the files graphsplit keeps next to the CAR files:
+// manifests, slice indexes, the file catalog and cached block indexes
+func isCarDirSidecar(name string) bool {
+	return IsManifestFile(name) || IsSliceIndex(name) || IsCatalogFile(name) ||
+		IsCarIndex(name) || IsCarChecksum(name) || strings.HasSuffix(name, ".tmp")
+}
+
 func ExistDir(path string) bool {
 	s, err := os.Stat(path)
 	if err != nil {
@@ -94,52 +283,165 @@ func ExistDir(path string) bool {
 	return s.IsDir()
 }
 
-func CarTo(carPath, outputDir string, parallel int) {
-	ctx := context.Background()
+// RestoreParams configures Restore
+type RestoreParams struct {
+	CarPath   string
+	OutputDir string
+	Parallel  int
+	// Filter selects the paths to restore, everything is restored when nil
+	Filter *PathFilter
+	Limits RestoreLimits
+	// OnConflict is the policy for entries that already exist in OutputDir, overwrite by default
+	OnConflict string
+	// DryRun lists the entries restore would write in the report without writing anything
+	DryRun bool
+	// Resume skips the CAR files a previous restore to OutputDir completed, see RestoreStateFile
+	Resume bool
+	// KeepGoing restores everything it can when a CAR file or an entry fails, instead of stopping
+	// at the first failure. Exceeding a limit or a conflict with the fail policy still stops it.
+	KeepGoing bool
+	// Verify checks the restored files against the checksums recorded in the sidecars at chunk
+	// time, the mismatches are listed in the report
+	Verify bool
+	// CacheIndex keeps the block index generated for a CARv1 next to it as <car>.idx, for the
+	// next restore or cat. By default the indexes only last for the restore, in a temp directory.
+	CacheIndex bool
+}
+
+// Restore writes the files of the CAR files in CarPath to OutputDir. Split files are pre-created
+// and every part is written straight at its offset, there is no merge pass. The report lists
+// the split files left incomplete by missing parts. When anything failed, the report comes with
+// a *RestoreError listing every failure, and with ErrChecksumMismatch when Verify found files
+// that differ from their checksums.
+func Restore(ctx context.Context, params *RestoreParams) (*RestoreReport, error) {
+	if params.Parallel <= 0 {
+		return nil, fmt.Errorf("parallel has to be greater than 0")
+	}
+	if params.OnConflict != "" {
+		if err := CheckConflictPolicy(params.OnConflict); err != nil {
+			return nil, err
+		}
+	}
+	r, cars := carTo(ctx, params)
+
+	report := buildRestoreReport(r.plan, r.carDirs)
+	report.Failures = r.failures
+	if params.Verify && !params.DryRun {
+		r.verify(ctx, cars, report)
+	}
+	if !params.DryRun && report.Complete() && len(r.failures) == 0 && r.state.allDone(cars) {
+		if err := r.state.remove(); err != nil {
+			log.Warn("failed to remove restore state, ", err)
+		}
+	}
+	if params.DryRun {
+		report.Entries = r.entries.list
+		sort.Slice(report.Entries, func(i, j int) bool {
+			return report.Entries[i].Path < report.Entries[j].Path
+		})
+	}
+	var errs []error
+	if len(r.failures) > 0 {
+		errs = append(errs, &RestoreError{Cars: len(cars), Failures: r.failures})
+	}
+	if len(report.Mismatches) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %d of %d files and parts differ from their checksums",
+			ErrChecksumMismatch, len(report.Mismatches), len(report.Mismatches)+report.Verified))
+	}
+	return report, errors.Join(errs...)
+}
+
+// restoreCarDirs returns carPath when it is a directory, then the other directories holding cars
+func restoreCarDirs(carPath string, cars []string) []string {
+	carDirs := []string{}
+	seen := make(map[string]bool)
+	if ExistDir(carPath) {
+		carDirs, seen[carPath] = append(carDirs, carPath), true
+	}
+	for _, p := range cars {
+		if dir := filepath.Dir(p); !seen[dir] {
+			carDirs, seen[dir] = append(carDirs, dir), true
+		}
+	}
+	return carDirs
+}
+
+// CarTo restores the CAR files in carPath to outputDir, the error lists every CAR file and entry that failed
+func CarTo(carPath, outputDir string, parallel int) error {
+	_, err := Restore(context.Background(), &RestoreParams{CarPath: carPath, OutputDir: outputDir, Parallel: parallel})
+	return err
+}
+
+func carTo(ctx context.Context, params *RestoreParams) (*restorer, []string) {
+	outputDir, parallel, filter := params.OutputDir, params.Parallel, params.Filter
+
+	var cars []string
+	err := filepath.Walk(params.CarPath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if isCarDirSidecar(fi.Name()) {
+			return nil
+		}
+		// if strings.ToLower(pa.Ext(fi.Name())) != ".car" {
+		// 	log.Warn(path, ", it's not a CAR file, skip it")
+		// 	return nil
+		// }
+		if filter != nil && !carMatchFilter(path, filter) {
+			log.Info(path, ", no selected path in it, skip it")
+			return nil
+		}
+		cars = append(cars, path)
+		return nil
+	})
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r := &restorer{params: params, cancel: cancel}
+	if err != nil {
+		r.fail(params.CarPath, err)
+	}
+	if params.Resume {
+		r.state, err = loadRestoreState(outputDir, filter)
+		if err != nil {
+			r.fail("", err)
+			r.abort(err)
+			return r, cars
+		}
+	} else {
+		r.state = newRestoreState(outputDir, filter)
+	}
+	// a dry run leaves the CAR directory as it is
+	r.indexes = newCarIndexCache(cars, params.CacheIndex && !params.DryRun)
+	defer r.indexes.remove()
+	r.carDirs = restoreCarDirs(params.CarPath, cars)
+	r.plan = planRestore(ctx, cars, r.carDirs, filter, r.indexes)
+	if err := r.createSplitFiles(); err != nil {
+		r.fail("", err)
+	}
 
 	workerCh := make(chan func())
 	go func() {
 		defer close(workerCh)
-		err := filepath.Walk(carPath, func(path string, fi os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if fi.IsDir() {
-				return nil
-			}
-			// if strings.ToLower(pa.Ext(fi.Name())) != ".car" {
-			// 	log.Warn(path, ", it's not a CAR file, skip it")
-			// 	return nil
-			// }
+		for _, path := range cars {
+			path := path
 			workerCh <- func() {
-				bs2 := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
-				rdag := merkledag.NewDAGService(blockservice.New(bs2, offline.Exchange(bs2)))
-				log.Info(path)
-				root, err := Import(ctx, path, bs2)
-				if err != nil {
-					log.Error("import error, ", err)
+				if ctx.Err() != nil {
 					return
 				}
-				nd, err := rdag.Get(ctx, root)
-				if err != nil {
-					log.Error("dagService.Get error, ", err)
+				if params.Resume && r.state.carDone(path) {
+					log.Info(path, ", restored by the previous restore, skip it")
 					return
 				}
-				file, err := unixfile.NewUnixfsFile(ctx, rdag, nd)
-				if err != nil {
-					log.Error("NewUnixfsFile error, ", err)
+				if err := r.restoreCar(ctx, path); err != nil {
+					r.fail(path, err)
 					return
 				}
-				defer file.Close()
-				err = NodeWriteTo(file, outputDir)
-				if err != nil {
-					log.Error("NodeWriteTo error, ", err)
-				}
+				r.state.setCarDone(path, true)
+				r.saveState()
 			}
-			return nil
-		})
-		if err != nil {
-			log.Error("Walk path failed, ", err)
 		}
 	}()
 
@@ -159,9 +461,84 @@ func CarTo(carPath, outputDir string, parallel int) {
 		}
 	}()
 	wg.Wait()
+	return r, cars
+}
+
+// restoreCar writes the roots of the CAR file carPath to the output directory. With KeepGoing
+// the failures of all its entries are joined in the error.
+func (r *restorer) restoreCar(ctx context.Context, carPath string) error {
+	outputDir := r.params.OutputDir
+	// blocks are read from the CAR file on demand, only the index of block offsets is kept in memory
+	bs, err := openCarBlockstore(carPath, r.indexes.path(carPath))
+	if err != nil {
+		return err
+	}
+	defer bs.Close()
+	rdag := bs.DAGService()
+	log.Info(carPath)
+	roots, err := carRoots(ctx, carPath, bs.Roots(), rdag)
+	if err != nil {
+		return err
+	}
+	// roots restored to their own entry go in the output directory
+	if roots[0].dagPath != "" && !r.params.DryRun {
+		if err := os.MkdirAll(outputDir, 0o777); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, root := range roots {
+		file, err := unixfile.NewUnixfsFile(ctx, rdag, root.node)
+		if err != nil {
+			return withPath(root.dagPath, err)
+		}
+		err = r.writeNode(file, filepath.Join(outputDir, filepath.FromSlash(root.dagPath)), root.dagPath)
+		file.Close()
+		if err != nil {
+			err = withPath(root.dagPath, err)
+			if !r.params.KeepGoing || abortsRestore(err) {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// carMatchFilter tells from the sidecar index, or else the catalog, whether the CAR file can hold
+// selected paths. A CAR known to neither is always read.
+func carMatchFilter(carPath string, filter *PathFilter) bool {
+	if index, err := ReadSliceIndex(carPath); err == nil {
+		return filter.MatchIndex(index)
+	}
+	paths, ok, err := catalogDagPaths(filepath.Dir(carPath), filepath.Base(carPath))
+	if err != nil {
+		log.Warn("failed to read catalog, ", err)
+	}
+	if !ok {
+		return true
+	}
+	for _, p := range paths {
+		if filter.Match(p) {
+			return true
+		}
+	}
+	return false
 }
 
-func Merge(dir string, parallel int) {
+// Merge joins the name.0000000N part files left in dir by versions of restore that did not write
+// split parts at their offsets. The error lists every file that failed to merge.
+func Merge(dir string, parallel int) error {
+	var mu sync.Mutex
+	var errs []error
+	var total int
+	fail := func(err error) {
+		log.Error(err)
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
 	wg := sync.WaitGroup{}
 	limitCh := make(chan struct{}, parallel)
 	mergeCh := make(chan string)
@@ -174,6 +551,7 @@ func Merge(dir string, parallel int) {
 				if !ok {
 					return
 				}
+				total++
 				limitCh <- struct{}{}
 				wg.Add(1)
 				go func() {
@@ -184,7 +562,7 @@ func Merge(dir string, parallel int) {
 					log.Info("merge to ", fpath)
 					f, err := os.Create(fpath)
 					if err != nil {
-						log.Error("Create file failed, ", err)
+						fail(fmt.Errorf("create %s: %w", fpath, err))
 						return
 					}
 					defer f.Close()
@@ -193,46 +571,52 @@ func Merge(dir string, parallel int) {
 						err := func(path string) error {
 							chunkF, err := os.Open(path)
 							if err != nil {
-								if os.IsExist(err) {
-									log.Error("Open file failed, ", err)
-								}
-								return err
+								return fmt.Errorf("open %s: %w", path, err)
 							}
 							defer chunkF.Close()
-							_, err = io.Copy(f, chunkF)
-							if err != nil {
-								log.Error("io.Copy failed, ", err)
+							if _, err := io.Copy(f, chunkF); err != nil {
+								return fmt.Errorf("copy %s to %s: %w", path, fpath, err)
 							}
-							return err
+							return nil
 						}(chunkPath)
-						os.Remove(chunkPath)
 						if err != nil {
+							// every failure is reported once, the end of the parts is no failure
+							if !errors.Is(err, os.ErrNotExist) {
+								fail(err)
+							} else if _, serr := os.Stat(fmt.Sprintf("%s.%08d", fpath, i+1)); serr == nil {
+								fail(fmt.Errorf("%s is incomplete, part %d is missing", fpath, i))
+							}
 							break
 						}
+						os.Remove(chunkPath)
 					}
 				}()
 			}
 		}
 	}()
 	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
-		if err != nil {
+		// the parts are removed as they are merged, while the walk goes on
+		if err != nil && (path == dir || !os.IsNotExist(err)) {
 			return err
 		}
-		if fi.IsDir() {
+		if err != nil || fi.IsDir() {
 			return nil
 		}
 		matched, err := filepath.Match("*.00000000", fi.Name())
 		if err != nil {
-			log.Error("filepath.Match failed, ", err)
-			return nil
+			return err
 		} else if matched {
 			mergeCh <- strings.TrimSuffix(path, ".00000000")
 		}
 		return nil
 	})
-	if err != nil {
-		log.Error("Walk path failed, ", err)
-	}
 	close(mergeCh)
 	wg.Wait()
+	if err != nil {
+		fail(fmt.Errorf("walk %s: %w", dir, err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("merge failed: %d errors in %d files: %w", len(errs), total, errors.Join(errs...))
+	}
+	return nil
 }
@@ -0,0 +1,156 @@
+package graphsplit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// what restore does with an entry whose path already exists in the output directory
+const (
+	ConflictOverwrite = "overwrite"
+	ConflictSkip      = "skip"
+	ConflictRename    = "rename"
+	ConflictFail      = "fail"
+)
+
+// ErrRestoreConflict is returned when an entry already exists and the conflict policy is fail
+var ErrRestoreConflict = errors.New("restore conflict")
+
+func CheckConflictPolicy(policy string) error {
+	switch policy {
+	case ConflictOverwrite, ConflictSkip, ConflictRename, ConflictFail:
+		return nil
+	}
+	return fmt.Errorf("unsupported conflict policy %q, expect one of overwrite, skip, rename, fail", policy)
+}
+
+const (
+	entryFile    = "file"
+	entryDir     = "dir"
+	entrySymlink = "symlink"
+)
+
+// RestoreEntry is an entry restore writes, or would write with a dry run
+type RestoreEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	// Action is write, keep for a file a resumed restore already wrote, or the conflict policy
+	// applied when the path already exists,
+	// RenameTo is the path relative to the output directory a renamed entry is written to
+	Action   string `json:"action"`
+	RenameTo string `json:"rename_to,omitempty"`
+}
+
+func (e *RestoreEntry) Conflict() bool {
+	return e.Action != "write" && e.Action != "keep"
+}
+
+// restoreEntries collects the entries of a dry run, renamed paths are reserved so that
+// concurrent workers never pick the same name
+type restoreEntries struct {
+	mu       sync.Mutex
+	list     []*RestoreEntry
+	reserved map[string]bool
+}
+
+func (re *restoreEntries) add(e *RestoreEntry) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	re.list = append(re.list, e)
+}
+
+// reserve picks the first free name (N) of fpath, like "photo (1).jpg"
+func (re *restoreEntries) reserve(fpath string) string {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	if re.reserved == nil {
+		re.reserved = make(map[string]bool)
+	}
+	dir, name := filepath.Split(fpath)
+	ext := filepath.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) && !re.reserved[candidate] {
+			re.reserved[candidate] = true
+			return candidate
+		}
+	}
+}
+
+// resolveConflict applies the conflict policy to an entry about to be written at fpath. It returns
+// the path to write the entry to, or an empty path when the entry is skipped. A directory merges
+// into an existing directory, anything else existing at fpath is a conflict, including a symlink
+// which restore never writes through.
+func (r *restorer) resolveConflict(fpath, dagPath, typ string, size int64) (string, error) {
+	entry := &RestoreEntry{Path: dagPath, Type: typ, Size: size, Action: "write"}
+	fi, err := os.Lstat(fpath)
+	switch {
+	case err != nil && !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR):
+		return "", err
+	case err != nil, typ == entryDir && fi.IsDir():
+		if r.params.DryRun && typ != entryDir {
+			r.entries.add(entry)
+		}
+		return fpath, nil
+	}
+
+	policy := r.params.OnConflict
+	if policy == "" {
+		policy = ConflictOverwrite
+	}
+	// with Resume, an existing file or symlink was written by the interrupted restore, a file
+	// of the expected size is complete and kept, anything else is written again
+	if r.params.Resume && typ != entryDir && !fi.IsDir() {
+		if typ == entryFile && fi.Mode().IsRegular() && fi.Size() == size {
+			if r.params.DryRun {
+				entry.Action = "keep"
+				r.entries.add(entry)
+			}
+			return "", nil
+		}
+		policy = ConflictOverwrite
+	}
+	entry.Action = policy
+	if policy == ConflictRename {
+		fpath = r.entries.reserve(fpath)
+		if rel, err := filepath.Rel(r.params.OutputDir, fpath); err == nil {
+			entry.RenameTo = filepath.ToSlash(rel)
+		}
+	}
+	if r.params.DryRun {
+		r.entries.add(entry)
+		if policy == ConflictSkip {
+			return "", nil
+		}
+		return fpath, nil
+	}
+
+	switch policy {
+	case ConflictSkip:
+		log.Infof("%s exists, skip it", fpath)
+		return "", nil
+	case ConflictFail:
+		return "", fmt.Errorf("%w: %s already exists", ErrRestoreConflict, fpath)
+	case ConflictOverwrite:
+		if fi.IsDir() {
+			return "", fmt.Errorf("cannot overwrite directory %s with a %s", fpath, typ)
+		}
+		// the existing file or symlink is removed rather than written through
+		if typ != entryFile || fi.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(fpath); err != nil {
+				return "", err
+			}
+		}
+	}
+	return fpath, nil
+}
@@ -0,0 +1,119 @@
+package graphsplit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RestoreFailure is a CAR file, or an entry of it, that failed to restore
+type RestoreFailure struct {
+	Car   string `json:"car,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error"`
+	err   error
+}
+
+func (f *RestoreFailure) String() string {
+	var b strings.Builder
+	if f.Car != "" {
+		b.WriteString(f.Car + ": ")
+	}
+	if f.Path != "" {
+		b.WriteString(f.Path + ": ")
+	}
+	b.WriteString(f.Error)
+	return b.String()
+}
+
+// RestoreError is returned by Restore when anything failed, it lists every failure
+type RestoreError struct {
+	// Cars is the number of CAR files of the restore
+	Cars     int
+	Failures []*RestoreFailure
+}
+
+func (e *RestoreError) Error() string {
+	failedCars := make(map[string]bool)
+	for _, f := range e.Failures {
+		if f.Car != "" {
+			failedCars[f.Car] = true
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "restore failed: %d errors in %d of %d CAR files", len(e.Failures), len(failedCars), e.Cars)
+	for _, f := range e.Failures {
+		b.WriteString("\n  " + f.String())
+	}
+	return b.String()
+}
+
+func (e *RestoreError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		errs = append(errs, f.err)
+	}
+	return errs
+}
+
+// pathError is a failure to restore the entry at dagPath
+type pathError struct {
+	dagPath string
+	err     error
+}
+
+func (e *pathError) Error() string {
+	return e.dagPath + ": " + e.err.Error()
+}
+
+func (e *pathError) Unwrap() error {
+	return e.err
+}
+
+// withPath tells which entry err comes from, unless a deeper entry was already told
+func withPath(dagPath string, err error) error {
+	var pe *pathError
+	if dagPath == "" || errors.As(err, &pe) {
+		return err
+	}
+	return &pathError{dagPath: dagPath, err: err}
+}
+
+// abortsRestore reports whether err stops the restore even with KeepGoing
+func abortsRestore(err error) bool {
+	return errors.Is(err, ErrRestoreLimit) || errors.Is(err, ErrRestoreConflict)
+}
+
+// fail records the failures of carPath, err may join the failures of several entries. Without
+// KeepGoing the restore is aborted on the first failure.
+func (r *restorer) fail(carPath string, err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			r.fail(carPath, e)
+		}
+		return
+	}
+	r.mu.Lock()
+	aborted := r.abortErr != nil
+	r.mu.Unlock()
+	// the workers stopped by an abort are not failures of their own
+	if aborted && errors.Is(err, context.Canceled) {
+		return
+	}
+
+	f := &RestoreFailure{Car: carPath, err: err}
+	var pe *pathError
+	if errors.As(err, &pe) {
+		f.Path, err = pe.dagPath, pe.err
+	}
+	f.Error = err.Error()
+	log.Error(f.String())
+
+	r.mu.Lock()
+	r.failures = append(r.failures, f)
+	r.mu.Unlock()
+	if !r.params.KeepGoing || abortsRestore(err) {
+		r.abort(err)
+	}
+}
@@ -0,0 +1,300 @@
+package graphsplit
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+// splitFile is a file split into parts at chunk time. Restore pre-creates it and writes
+// every part straight at its offset, from whichever CAR holds the part.
+type splitFile struct {
+	// size and path of the original file, as recorded by the sidecars, size is -1 when unknown
+	size    int64
+	srcPath string
+	// end is where the last part with a known offset ends
+	end   int64
+	parts map[int]*splitPart
+	// fpath is where the file is restored, skip is set when it already exists and is skipped
+	fpath string
+	skip  bool
+}
+
+type splitPart struct {
+	size int64
+	// offset of the part in the original file, -1 when unknown
+	offset int64
+	// car is the CAR file holding the part, done is set once the part is written
+	car  string
+	done bool
+}
+
+// restorePlan maps the path inside the graph of every split file to its parts
+type restorePlan map[string]*splitFile
+
+func (p restorePlan) add(dagPath string, idx int, part *splitPart, src *fsSource) {
+	sf, ok := p[dagPath]
+	if !ok {
+		sf = &splitFile{size: -1, parts: make(map[int]*splitPart)}
+		p[dagPath] = sf
+	}
+	if src != nil {
+		sf.size, sf.srcPath = src.Size, src.Path
+	}
+	if _, ok := sf.parts[idx]; !ok {
+		sf.parts[idx] = part
+	}
+}
+
+// part returns the split file and part that dagPath is, if any
+func (p restorePlan) part(dagPath string) (*splitFile, *splitPart, string) {
+	base := trimSplitPart(dagPath)
+	if base == dagPath {
+		return nil, nil, ""
+	}
+	sf, ok := p[base]
+	if !ok {
+		return nil, nil, ""
+	}
+	idx, _ := matchSplitPart(path.Base(dagPath), path.Base(base))
+	return sf, sf.parts[idx], base
+}
+
+// resolve computes the offsets the sidecars did not record from the sizes of the previous parts
+func (p restorePlan) resolve() {
+	for _, sf := range p {
+		idxs := make([]int, 0, len(sf.parts))
+		for idx := range sf.parts {
+			idxs = append(idxs, idx)
+		}
+		sort.Ints(idxs)
+		var end int64
+		known, next := true, 0
+		for _, i := range idxs {
+			part := sf.parts[i]
+			// the offset follows from the previous part when no part is missing in between
+			if part.offset < 0 && known && i == next {
+				part.offset = end
+			}
+			next = i + 1
+			known = part.offset >= 0
+			if known && part.offset+part.size > end {
+				end = part.offset + part.size
+			}
+		}
+		sf.end = end
+	}
+}
+
+func (sf *splitFile) fileSize() int64 {
+	if sf.size < 0 {
+		return sf.end
+	}
+	return sf.size
+}
+
+// createSplitFiles applies the conflict policy to the split files of the plan and pre-creates them
+// at their full size, or up to the end of their known parts. With Resume, a split file the previous
+// restore pre-created is kept when it still has its size, along with the parts of the CAR files
+// that restore completed. Otherwise these CAR files are restored again.
+func (r *restorer) createSplitFiles() error {
+	dagPaths := make([]string, 0, len(r.plan))
+	for dagPath := range r.plan {
+		dagPaths = append(dagPaths, dagPath)
+	}
+	sort.Strings(dagPaths)
+	var create []string
+	for _, dagPath := range dagPaths {
+		sf := r.plan[dagPath]
+		fpath := filepath.Join(r.params.OutputDir, filepath.FromSlash(dagPath))
+		if err := checkNoSymlinkIn(r.params.OutputDir, fpath); err != nil {
+			r.fail("", withPath(dagPath, err))
+			continue
+		}
+		if r.params.Resume && r.state.hasSplitFile(dagPath) {
+			sf.fpath = fpath
+			if r.state.splitFileDone(dagPath, fpath, sf.fileSize()) {
+				continue
+			}
+			log.Warnf("%s was not fully pre-created by the previous restore, restore it again", fpath)
+			for _, part := range sf.parts {
+				r.state.setCarDone(part.car, false)
+			}
+		} else {
+			fpath, err := r.resolveConflict(fpath, dagPath, entryFile, sf.fileSize())
+			if err != nil {
+				return err
+			}
+			sf.fpath, sf.skip = fpath, fpath == ""
+			if sf.skip {
+				continue
+			}
+		}
+		if !r.params.DryRun {
+			r.state.setSplitFile(dagPath, sf.fileSize())
+			create = append(create, dagPath)
+		}
+	}
+	if r.params.Resume {
+		for _, sf := range r.plan {
+			for _, part := range sf.parts {
+				part.done = !sf.skip && r.state.carDone(part.car)
+			}
+		}
+	}
+
+	// the state records the split files before they are created, a split file is never mistaken
+	// for a complete file by a resumed restore
+	r.saveState()
+	for _, dagPath := range create {
+		if err := r.plan[dagPath].create(); err != nil {
+			r.fail("", withPath(dagPath, err))
+		}
+	}
+	return nil
+}
+
+func (sf *splitFile) create() error {
+	fpath := sf.fpath
+	if err := os.MkdirAll(filepath.Dir(fpath), 0o777); err != nil {
+		return err
+	}
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(sf.fileSize()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// planRestore collects the parts of the split files selected by filter in cars. Parts are read
+// from the sidecar index when there is one, otherwise from the directories of the CAR. Without
+// a sidecar or a catalog of carDirs to tell, names like <name>.00000001 are only the parts of
+// a split file when part 0 is there, otherwise every entry is restored under its own name.
+func planRestore(ctx context.Context, cars, carDirs []string, filter *PathFilter, indexes *carIndexCache) restorePlan {
+	plan := make(restorePlan)
+	for _, carPath := range cars {
+		if index, err := ReadSliceIndex(carPath); err == nil && index.Root != nil {
+			planFromIndex(plan, carPath, index.Root, "", filter)
+			continue
+		}
+		if err := planFromCar(ctx, plan, carPath, filter, indexes.path(carPath)); err != nil {
+			log.Errorf("failed to read the split files of %s: %s", carPath, err)
+		}
+	}
+	for dagPath, sf := range plan {
+		if _, ok := sf.parts[0]; ok || sf.srcPath != "" || inCatalogs(carDirs, dagPath) {
+			continue
+		}
+		delete(plan, dagPath)
+	}
+	plan.resolve()
+	return plan
+}
+
+// inCatalogs reports whether a catalog of carDirs records parts of the split file at dagPath
+func inCatalogs(carDirs []string, dagPath string) bool {
+	for _, dir := range carDirs {
+		if parts, err := catalogSplitParts(dir, dagPath); err == nil && len(parts) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func planFromIndex(plan restorePlan, carPath string, nd *fsNode, dagPath string, filter *PathFilter) {
+	for i := range nd.Link {
+		ln := &nd.Link[i]
+		if checkEntryName(ln.Name) != nil {
+			continue
+		}
+		childPath := path.Join(dagPath, ln.Name)
+		if len(ln.Link) > 0 {
+			if filter.MayContain(childPath) {
+				planFromIndex(plan, carPath, ln, childPath, filter)
+			}
+			continue
+		}
+		base := trimSplitPart(childPath)
+		if base == childPath || !filter.Match(childPath) {
+			continue
+		}
+		// a leaf without source is an empty directory, a part as long as its source is a whole file
+		if ln.Src != nil && ln.Src.Length < ln.Src.Size {
+			idx, _ := matchSplitPart(ln.Name, path.Base(base))
+			plan.add(base, idx, &splitPart{size: ln.Src.Length, offset: ln.Src.Offset, car: carPath}, ln.Src)
+		}
+	}
+}
+
+func planFromCar(ctx context.Context, plan restorePlan, carPath string, filter *PathFilter, indexPath string) error {
+	bs, err := openCarBlockstore(carPath, indexPath)
+	if err != nil {
+		return err
+	}
+	defer bs.Close()
+	dserv := bs.DAGService()
+	roots, err := carRoots(ctx, carPath, bs.Roots(), dserv)
+	if err != nil {
+		return err
+	}
+
+	var walk func(nd ipld.Node, dagPath string) error
+	walk = func(nd ipld.Node, dagPath string) error {
+		links, err := listDir(ctx, dserv, nd)
+		if err != nil {
+			return err
+		}
+		for _, ln := range links {
+			if checkEntryName(ln.Name) != nil {
+				continue
+			}
+			childPath := path.Join(dagPath, ln.Name)
+			base := trimSplitPart(childPath)
+			if base == childPath && !filter.MayContain(childPath) || base != childPath && !filter.Match(childPath) {
+				continue
+			}
+			child, err := ln.GetNode(ctx, dserv)
+			if err != nil {
+				return err
+			}
+			size, ok := unixfsFileSize(child)
+			if base == childPath || !ok {
+				if err := walk(child, childPath); err != nil {
+					return err
+				}
+				continue
+			}
+			idx, _ := matchSplitPart(ln.Name, path.Base(base))
+			plan.add(base, idx, &splitPart{size: size, offset: -1, car: carPath}, nil)
+		}
+		return nil
+	}
+	for _, root := range roots {
+		if err := walk(root.node, root.dagPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unixfsFileSize returns the size of the file nd, ok is false when nd is not a file
+func unixfsFileSize(nd ipld.Node) (int64, bool) {
+	if raw, ok := nd.(*merkledag.RawNode); ok {
+		return int64(len(raw.RawData())), true
+	}
+	fsn, err := unixfs.ExtractFSNode(nd)
+	if err != nil || fsn.IsDir() {
+		return 0, false
+	}
+	return int64(fsn.FileSize()), true
+}
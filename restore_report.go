@@ -0,0 +1,170 @@
+package graphsplit
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// RestoreReport lists the split files restore could not complete, the CAR files and entries
+// that failed, the files that differ from their checksums with Verify, and with a dry run
+// the entries restore would write
+type RestoreReport struct {
+	Incomplete []*IncompleteFile `json:"incomplete"`
+	Failures   []*RestoreFailure `json:"failures,omitempty"`
+	// Verified counts the files and parts matching their checksums, Unverified the restored
+	// ones chunked without checksums
+	Verified   int                 `json:"verified,omitempty"`
+	Unverified int                 `json:"unverified,omitempty"`
+	Mismatches []*ChecksumMismatch `json:"mismatches,omitempty"`
+	Entries    []*RestoreEntry     `json:"entries,omitempty"`
+}
+
+// IncompleteFile is a split file with missing parts, its missing byte ranges are left as holes
+type IncompleteFile struct {
+	Path string `json:"path"`
+	// Size is the size of the original file, -1 when unknown
+	Size    int64          `json:"size"`
+	Missing []*MissingPart `json:"missing"`
+}
+
+// MissingPart is a part that was not restored, along with the slice holding it when it is known.
+// Offset and Length are -1 when unknown. A run of unknown parts, or a missing tail, is reported
+// as one part from the first missing index.
+type MissingPart struct {
+	Part       int    `json:"part"`
+	Offset     int64  `json:"offset"`
+	Length     int64  `json:"length"`
+	SliceName  string `json:"slice_name,omitempty"`
+	CarFile    string `json:"car_file,omitempty"`
+	PayloadCid string `json:"payload_cid,omitempty"`
+	PieceCid   string `json:"piece_cid,omitempty"`
+}
+
+func (r *RestoreReport) Complete() bool {
+	return len(r.Incomplete) == 0
+}
+
+// Save writes the report as json to fpath, or stdout when fpath is -
+func (r *RestoreReport) Save(fpath string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if fpath == "-" {
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	return os.WriteFile(fpath, append(data, '\n'), 0o644)
+}
+
+// buildRestoreReport checks every split file of the plan has a contiguous set of parts covering
+// its whole size. The catalogs of carDirs tell which slices hold the missing parts.
+func buildRestoreReport(plan restorePlan, carDirs []string) *RestoreReport {
+	report := &RestoreReport{Incomplete: []*IncompleteFile{}}
+	for dagPath, sf := range plan {
+		if sf.skip {
+			continue
+		}
+		// parts recorded in a catalog, for the same source file when the sidecars tell which it is
+		known := make(map[int]*CatalogEntry)
+		for _, dir := range carDirs {
+			entries, err := catalogSplitParts(dir, dagPath)
+			if err != nil {
+				log.Warn("failed to read catalog, ", err)
+				continue
+			}
+			for _, e := range entries {
+				if sf.srcPath != "" && e.SourcePath != sf.srcPath {
+					continue
+				}
+				idx, ok := matchSplitPart(path.Base(e.DagPath), path.Base(dagPath))
+				if !ok {
+					continue
+				}
+				if _, ok := known[idx]; !ok {
+					known[idx] = e
+				}
+				if sf.size < 0 {
+					sf.size = e.SourceSize
+				}
+			}
+		}
+		if f := checkSplitFile(dagPath, sf, known); f != nil {
+			report.Incomplete = append(report.Incomplete, f)
+		}
+	}
+	sort.Slice(report.Incomplete, func(i, j int) bool {
+		return report.Incomplete[i].Path < report.Incomplete[j].Path
+	})
+	return report
+}
+
+func checkSplitFile(dagPath string, sf *splitFile, known map[int]*CatalogEntry) *IncompleteFile {
+	// walk the indices of the known parts only, a name like backup.20230101 is part 20230101
+	idxs := make([]int, 0, len(sf.parts)+len(known))
+	for idx := range sf.parts {
+		idxs = append(idxs, idx)
+	}
+	for idx := range known {
+		if _, ok := sf.parts[idx]; !ok {
+			idxs = append(idxs, idx)
+		}
+	}
+	sort.Ints(idxs)
+
+	f := &IncompleteFile{Path: dagPath, Size: sf.size}
+	var end int64
+	next := 0
+	for _, i := range idxs {
+		part, ok := sf.parts[i]
+		e := known[i]
+		start := int64(-1)
+		switch {
+		case e != nil:
+			start = e.Offset
+		case ok:
+			start = part.offset
+		}
+		if i > next {
+			// the run of unknown parts between the previous part and this one
+			gap := &MissingPart{Part: next, Offset: end, Length: -1}
+			if end >= 0 && start >= 0 {
+				gap.Length = start - end
+			}
+			f.Missing = append(f.Missing, gap)
+			end = start
+		}
+		next = i + 1
+		if ok && part.done {
+			end = part.offset + part.size
+			continue
+		}
+		missing := &MissingPart{Part: i, Offset: start}
+		if e != nil {
+			missing.Length = e.Length
+			missing.SliceName, missing.CarFile, missing.PayloadCid, missing.PieceCid = e.SliceName, e.CarFile, e.PayloadCid, e.PieceCid
+		} else {
+			// the CAR holding the part failed to restore
+			missing.Length, missing.CarFile = part.size, filepath.Base(part.car)
+		}
+		if missing.Offset < 0 {
+			missing.Offset = end
+		}
+		if missing.Offset >= 0 {
+			end = missing.Offset + missing.Length
+		} else {
+			end = -1
+		}
+		f.Missing = append(f.Missing, missing)
+	}
+	if end >= 0 && sf.size > end {
+		f.Missing = append(f.Missing, &MissingPart{Part: next, Offset: end, Length: sf.size - end})
+	}
+	if len(f.Missing) == 0 {
+		return nil
+	}
+	return f
+}
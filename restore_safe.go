@@ -0,0 +1,140 @@
+package graphsplit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrRestoreLimit is returned when a restore exceeds one of its RestoreLimits
+var ErrRestoreLimit = errors.New("restore limit exceeded")
+
+// RestoreLimits bounds what restoring untrusted CAR files may write, zero means no limit
+type RestoreLimits struct {
+	// MaxBytes is the total size of the restored files
+	MaxBytes int64
+	// MaxEntries is the number of restored files, directories and symlinks
+	MaxEntries int64
+	// MaxDepth is the nesting depth of the restored paths
+	MaxDepth int
+}
+
+// restoreUsage counts what a restore wrote so far, it is shared by the restore workers
+type restoreUsage struct {
+	bytes   atomic.Int64
+	entries atomic.Int64
+}
+
+// addEntry counts the entry dagPath in usage, it fails when the entry exceeds the limits
+func (limits RestoreLimits) addEntry(usage *restoreUsage, dagPath string) error {
+	if dagPath == "" {
+		return nil
+	}
+	if limits.MaxDepth > 0 && strings.Count(dagPath, "/")+1 > limits.MaxDepth {
+		return fmt.Errorf("%w: %s is deeper than %d", ErrRestoreLimit, dagPath, limits.MaxDepth)
+	}
+	if n := usage.entries.Add(1); limits.MaxEntries > 0 && n > limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrRestoreLimit, limits.MaxEntries)
+	}
+	return nil
+}
+
+// addBytes counts n bytes in usage, it fails when they exceed the limits
+func (limits RestoreLimits) addBytes(usage *restoreUsage, n int64) error {
+	if total := usage.bytes.Add(n); limits.MaxBytes > 0 && total > limits.MaxBytes {
+		return fmt.Errorf("%w: more than %d bytes", ErrRestoreLimit, limits.MaxBytes)
+	}
+	return nil
+}
+
+func (r *restorer) addEntry(dagPath string) error {
+	return r.params.Limits.addEntry(&r.usage, dagPath)
+}
+
+func (r *restorer) addBytes(n int64) error {
+	return r.params.Limits.addBytes(&r.usage, n)
+}
+
+// limitWriter fails once the restore wrote more than its MaxBytes
+type limitWriter struct {
+	w io.Writer
+	r *restorer
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if err := lw.r.addBytes(int64(len(p))); err != nil {
+		return 0, err
+	}
+	return lw.w.Write(p)
+}
+
+// checkEntryName rejects directory entry names that are not a single path element,
+// so that a crafted CAR cannot write outside the output directory
+func checkEntryName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("invalid entry name %q", name)
+	}
+	return nil
+}
+
+// checkSymlinkTarget rejects symlink targets that are absolute or point out of the output directory.
+// A .. is only accepted at the start of the target, after a name it could step back from another
+// symlink and escape even though the target looks inside once cleaned.
+func checkSymlinkTarget(dagPath, target string) error {
+	if target == "" || strings.ContainsRune(target, 0) || path.IsAbs(target) || filepath.IsAbs(target) ||
+		filepath.VolumeName(target) != "" {
+		return fmt.Errorf("symlink %s has an unsafe target %q", dagPath, target)
+	}
+	target = filepath.ToSlash(target)
+	named := false
+	for _, elem := range strings.Split(target, "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if named {
+				return fmt.Errorf("symlink %s has an unsafe target %q", dagPath, target)
+			}
+		default:
+			named = true
+		}
+	}
+	resolved := path.Join(path.Dir(dagPath), target)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("symlink %s points out of the output directory: %q", dagPath, target)
+	}
+	return nil
+}
+
+// checkNotSymlink fails when fpath exists as a symlink, restore never writes through one
+// as it could have been planted by a previous entry to point out of the output directory
+func checkNotSymlink(fpath string) error {
+	fi, err := os.Lstat(fpath)
+	if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("refuse to write through symlink %s", fpath)
+	}
+	return nil
+}
+
+// checkNoSymlinkIn checks that no path element of fpath below root is a symlink
+func checkNoSymlinkIn(root, fpath string) error {
+	rel, err := filepath.Rel(root, fpath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is out of %s", fpath, root)
+	}
+	if rel == "." {
+		return nil
+	}
+	p := root
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, name)
+		if err := checkNotSymlink(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package graphsplit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// RestoreStateFile is kept in the output directory to record the progress of a restore,
+// it is removed once the restore is complete
+const RestoreStateFile = ".graphsplit-restore.json"
+
+// restoreState records which CAR files are fully restored and which split files were
+// pre-created, so that a resumed restore skips them
+type restoreState struct {
+	mu    sync.Mutex
+	fpath string
+
+	// the filter of the restore, a state is only resumed with the same filter
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// Cars maps the absolute path of a CAR file to its progress
+	Cars map[string]*carState `json:"cars"`
+	// SplitFiles maps the path inside the graph of a pre-created split file to its size
+	SplitFiles map[string]int64 `json:"split_files"`
+}
+
+type carState struct {
+	// Size of the CAR file, a CAR that changed since is restored again
+	Size int64 `json:"size"`
+	Done bool  `json:"done"`
+}
+
+func newRestoreState(outputDir string, filter *PathFilter) *restoreState {
+	s := &restoreState{
+		fpath:      filepath.Join(outputDir, RestoreStateFile),
+		Cars:       make(map[string]*carState),
+		SplitFiles: make(map[string]int64),
+	}
+	if filter != nil {
+		s.Include, s.Exclude = filter.Include, filter.Exclude
+	}
+	return s
+}
+
+// loadRestoreState reads the state left in outputDir by a previous restore with the same filter,
+// an empty state is returned when there is none
+func loadRestoreState(outputDir string, filter *PathFilter) (*restoreState, error) {
+	s := newRestoreState(outputDir, filter)
+	data, err := os.ReadFile(s.fpath)
+	if os.IsNotExist(err) {
+		log.Warnf("no restore state in %s, restore from scratch", outputDir)
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prev := newRestoreState(outputDir, nil)
+	if err := json.Unmarshal(data, prev); err != nil {
+		return nil, fmt.Errorf("failed to read restore state %s: %w", s.fpath, err)
+	}
+	if !slices.Equal(prev.Include, s.Include) || !slices.Equal(prev.Exclude, s.Exclude) {
+		return nil, fmt.Errorf("restore state %s was written with other include or exclude patterns", s.fpath)
+	}
+	prev.Include, prev.Exclude = s.Include, s.Exclude
+	return prev, nil
+}
+
+// carDone reports whether carPath was fully restored and has not changed since
+func (s *restoreState) carDone(carPath string) bool {
+	key, size, err := carStateKey(carPath)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, ok := s.Cars[key]
+	return ok && cs.Done && cs.Size == size
+}
+
+func (s *restoreState) setCarDone(carPath string, done bool) {
+	key, size, err := carStateKey(carPath)
+	if err != nil {
+		log.Warn("failed to record restore state, ", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Cars[key] = &carState{Size: size, Done: done}
+}
+
+// allDone reports whether all of cars were fully restored
+func (s *restoreState) allDone(cars []string) bool {
+	for _, carPath := range cars {
+		if !s.carDone(carPath) {
+			return false
+		}
+	}
+	return true
+}
+
+func carStateKey(carPath string) (string, int64, error) {
+	abs, err := filepath.Abs(carPath)
+	if err != nil {
+		return "", 0, err
+	}
+	fi, err := os.Stat(carPath)
+	if err != nil {
+		return "", 0, err
+	}
+	return abs, fi.Size(), nil
+}
+
+// splitFileDone reports whether the split file at fpath was pre-created by a previous restore
+// and still has its full size
+func (s *restoreState) splitFileDone(dagPath, fpath string, size int64) bool {
+	s.mu.Lock()
+	recorded, ok := s.SplitFiles[dagPath]
+	s.mu.Unlock()
+	if !ok || recorded != size {
+		return false
+	}
+	fi, err := os.Lstat(fpath)
+	return err == nil && fi.Mode().IsRegular() && fi.Size() == size
+}
+
+func (s *restoreState) hasSplitFile(dagPath string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.SplitFiles[dagPath]
+	return ok
+}
+
+func (s *restoreState) setSplitFile(dagPath string, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SplitFiles[dagPath] = size
+}
+
+// save writes the state to a temporary file first, so that an interrupted save never leaves
+// a truncated state behind
+func (s *restoreState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.fpath), 0o777); err != nil {
+		return err
+	}
+	tmp := s.fpath + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.fpath)
+}
+
+// saveState saves the progress of the restore, a dry run never writes it
+func (r *restorer) saveState() {
+	if r.params.DryRun {
+		return
+	}
+	if err := r.state.save(); err != nil {
+		log.Warn("failed to save restore state, ", err)
+	}
+}
+
+func (s *restoreState) remove() error {
+	err := os.Remove(s.fpath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,366 @@
+package graphsplit
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// tarEntry is a file, directory or symlink of the graph, collected from every CAR of the set
+type tarEntry struct {
+	typ    string
+	target string
+	mode   int64
+	mtime  time.Time
+	// parts of a file, index -1 for a whole file
+	parts map[int]*tarPart
+}
+
+type tarPart struct {
+	car  *carSetEntry
+	cid  cid.Cid
+	size int64
+}
+
+// tarCollector gathers the entries of the tar stream from the CAR files, within the limits
+type tarCollector struct {
+	filter  *PathFilter
+	limits  RestoreLimits
+	usage   restoreUsage
+	entries map[string]*tarEntry
+}
+
+// RestoreTar writes the files of the CAR files in params.CarPath to w as a tar stream instead of a
+// directory, the paths selected by params.Filter within params.Limits. Split files are stitched
+// from whichever CAR holds their parts, entries are ordered by path and carry the mode and mtime
+// the DAG records, so the same CAR files always give the same stream. The limits are checked
+// before anything is written. OutputDir, OnConflict, DryRun, Resume, KeepGoing and Verify are for
+// a restore to a directory, they are rejected.
+func RestoreTar(ctx context.Context, params *RestoreParams, w io.Writer) error {
+	if params.OutputDir != "" || params.OnConflict != "" || params.DryRun || params.Resume || params.KeepGoing || params.Verify {
+		return fmt.Errorf("output dir, conflict policy, dry run, resume, keep going and verify are not supported with tar")
+	}
+	cs, err := OpenCarSet(params.CarPath)
+	if err != nil {
+		return err
+	}
+	defer cs.Close()
+	// every CAR is read once, its index is only worth keeping for a later restore
+	if !params.CacheIndex {
+		for _, car := range cs.cars {
+			car.indexPath = ""
+		}
+	}
+
+	filter := params.Filter
+	tc := &tarCollector{filter: filter, limits: params.Limits, entries: make(map[string]*tarEntry)}
+	for _, car := range cs.cars {
+		if filter != nil && !carMatchFilter(car.path, filter) {
+			continue
+		}
+		if err := tc.collect(ctx, car); err != nil {
+			return err
+		}
+	}
+	entries := tc.entries
+
+	// directories are written when selected, or as the parents of a selected entry
+	selected := make(map[string]bool)
+	for dagPath, e := range entries {
+		if e.typ != entryDir || filter.Match(dagPath) {
+			for p := dagPath; p != "." && !selected[p]; p = path.Dir(p) {
+				selected[p] = true
+			}
+		}
+	}
+	dagPaths := make([]string, 0, len(selected))
+	for dagPath := range selected {
+		if _, ok := entries[dagPath]; !ok {
+			entries[dagPath] = &tarEntry{typ: entryDir, mode: -1}
+		}
+		dagPaths = append(dagPaths, dagPath)
+	}
+	sort.Strings(dagPaths)
+
+	tw := tar.NewWriter(w)
+	for _, dagPath := range dagPaths {
+		if err := writeTarEntry(ctx, tw, dagPath, entries[dagPath]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// collect adds the entries of car, the entries of the files, directories and symlinks new to the
+// stream and the bytes of the parts are counted against the limits as they are found
+func (tc *tarCollector) collect(ctx context.Context, car *carSetEntry) error {
+	filter, entries := tc.filter, tc.entries
+	if err := car.open(); err != nil {
+		return err
+	}
+	roots, err := carRoots(ctx, car.path, car.bs.Roots(), car.dserv)
+	if err != nil {
+		return err
+	}
+
+	var walk func(nd ipld.Node, dagPath string) error
+	// add records the entry nd named name at dagPath
+	add := func(nd ipld.Node, name, dagPath string) error {
+		if !filter.MayContain(dagPath) {
+			return nil
+		}
+		e := &tarEntry{mode: -1}
+		e.mode, e.mtime = unixfsMeta(nd)
+		if fsn, err := unixfs.ExtractFSNode(nd); err == nil && fsn.IsDir() {
+			if _, ok := entries[dagPath]; !ok {
+				if err := tc.limits.addEntry(&tc.usage, dagPath); err != nil {
+					return err
+				}
+				e.typ = entryDir
+				entries[dagPath] = e
+			}
+			return walk(nd, dagPath)
+		} else if err == nil && fsn.Type() == unixfs.TSymlink {
+			if !filter.Match(dagPath) {
+				return nil
+			}
+			if err := checkSymlinkTarget(dagPath, string(fsn.Data())); err != nil {
+				return err
+			}
+			e.typ, e.target = entrySymlink, string(fsn.Data())
+			if _, ok := entries[dagPath]; !ok {
+				if err := tc.limits.addEntry(&tc.usage, dagPath); err != nil {
+					return err
+				}
+				entries[dagPath] = e
+			}
+			return nil
+		}
+		size, ok := unixfsFileSize(nd)
+		if !ok {
+			return fmt.Errorf("%s in %s is not a unixfs file", dagPath, car.path)
+		}
+		if !filter.Match(dagPath) {
+			return nil
+		}
+		base := trimSplitPart(dagPath)
+		idx, _ := matchSplitPart(name, path.Base(base))
+		if base == dagPath {
+			idx = -1
+		}
+		fe, ok := entries[base]
+		if !ok {
+			if err := tc.limits.addEntry(&tc.usage, base); err != nil {
+				return err
+			}
+			e.typ, e.parts = entryFile, make(map[int]*tarPart)
+			fe, entries[base] = e, e
+		}
+		if _, ok := fe.parts[idx]; !ok {
+			if err := tc.limits.addBytes(&tc.usage, int64(size)); err != nil {
+				return err
+			}
+			fe.parts[idx] = &tarPart{car: car, cid: nd.Cid(), size: size}
+		}
+		return nil
+	}
+	walk = func(nd ipld.Node, dagPath string) error {
+		links, err := listDir(ctx, car.dserv, nd)
+		if err != nil {
+			return err
+		}
+		for _, ln := range links {
+			if err := checkEntryName(ln.Name); err != nil {
+				return fmt.Errorf("%s: %w", dagPath, err)
+			}
+			if !filter.MayContain(path.Join(dagPath, ln.Name)) {
+				continue
+			}
+			child, err := ln.GetNode(ctx, car.dserv)
+			if err != nil {
+				return err
+			}
+			if err := add(child, ln.Name, path.Join(dagPath, ln.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, root := range roots {
+		if root.dagPath == "" {
+			err = walk(root.node, "")
+		} else {
+			err = add(root.node, root.dagPath, root.dagPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeTarEntry(ctx context.Context, tw *tar.Writer, dagPath string, e *tarEntry) error {
+	hdr := &tar.Header{Name: dagPath, Mode: e.mode, ModTime: e.mtime}
+	if e.mtime.IsZero() {
+		hdr.ModTime = time.Unix(0, 0)
+	}
+	var parts []*tarPart
+	switch e.typ {
+	case entryDir:
+		hdr.Typeflag, hdr.Name = tar.TypeDir, dagPath+"/"
+		if hdr.Mode < 0 {
+			hdr.Mode = 0o755
+		}
+	case entrySymlink:
+		hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, e.target
+		if hdr.Mode < 0 {
+			hdr.Mode = 0o777
+		}
+	default:
+		var err error
+		if parts, err = e.orderedParts(dagPath); err != nil {
+			return err
+		}
+		hdr.Typeflag = tar.TypeReg
+		for _, p := range parts {
+			hdr.Size += p.size
+		}
+		if hdr.Mode < 0 {
+			hdr.Mode = 0o644
+		}
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err := p.copyTo(ctx, tw); err != nil {
+			return fmt.Errorf("failed to read %s from %s: %w", dagPath, p.car.path, err)
+		}
+	}
+	return nil
+}
+
+// orderedParts returns the whole file, or its parts in order, failing when a part is missing
+// as the size of a tar entry has to be known before its content
+func (e *tarEntry) orderedParts(dagPath string) ([]*tarPart, error) {
+	if whole, ok := e.parts[-1]; ok {
+		return []*tarPart{whole}, nil
+	}
+	idxs := make([]int, 0, len(e.parts))
+	for idx := range e.parts {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	parts := make([]*tarPart, 0, len(idxs))
+	for i, idx := range idxs {
+		if idx != i {
+			return nil, fmt.Errorf("part %s of %s is missing", splitPartName(dagPath, i), dagPath)
+		}
+		parts = append(parts, e.parts[idx])
+	}
+	return parts, nil
+}
+
+func (p *tarPart) copyTo(ctx context.Context, w io.Writer) error {
+	nd, err := p.car.dserv.Get(ctx, p.cid)
+	if err != nil {
+		return err
+	}
+	dr, err := uio.NewDagReader(ctx, nd, p.car.dserv)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	n, err := io.Copy(w, dr)
+	if err == nil && n != p.size {
+		err = fmt.Errorf("read %d bytes, expect %d", n, p.size)
+	}
+	return err
+}
+
+// unixfsMeta reads the optional mode and mtime of a UnixFS node, fields 7 and 8 of its data
+// which go-unixfs does not decode. mode is -1 when the node has none.
+func unixfsMeta(nd ipld.Node) (int64, time.Time) {
+	mode, mtime := int64(-1), time.Time{}
+	pn, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		return mode, mtime
+	}
+	data := pn.Data()
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			break
+		}
+		data = data[n:]
+		switch {
+		case num == 7 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return mode, mtime
+			}
+			mode = int64(v & 0o7777)
+			data = data[n:]
+		case num == 8 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return mode, mtime
+			}
+			mtime = parseUnixTime(v)
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return mode, mtime
+			}
+			data = data[n:]
+		}
+	}
+	return mode, mtime
+}
+
+// parseUnixTime decodes a UnixFS UnixTime message, seconds in field 1 and nanoseconds in field 2
+func parseUnixTime(data []byte) time.Time {
+	var secs int64
+	var nanos uint32
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return time.Time{}
+		}
+		data = data[n:]
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return time.Time{}
+			}
+			secs, data = int64(v), data[n:]
+		case num == 2 && typ == protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(data)
+			if n < 0 {
+				return time.Time{}
+			}
+			nanos, data = v, data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return time.Time{}
+			}
+			data = data[n:]
+		}
+	}
+	return time.Unix(secs, int64(nanos)).UTC()
+}
@@ -0,0 +1,976 @@
+package graphsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	"github.com/ipfs/go-unixfs"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+	uio "github.com/ipfs/go-unixfs/io"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	carv2 "github.com/ipld/go-car/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// listFiles returns the relative paths of the regular files under dir
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var list []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		list = append(list, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestRestoreFilter(t *testing.T) {
+	carDir := t.TempDir()
+	dataDir := chunkTestData(t, carDir, 64<<10)
+
+	cases := []struct {
+		include, exclude []string
+		expect           []string
+	}{
+		{nil, nil, []string{"a/b/x.txt", "a/big.bin", "c/empty.md", "y.txt"}},
+		{[]string{"a/big.bin"}, nil, []string{"a/big.bin"}},
+		{[]string{"*.txt"}, nil, []string{"a/b/x.txt", "y.txt"}},
+		{[]string{"a"}, []string{"a/b"}, []string{"a/big.bin"}},
+		{nil, []string{"a/big.bin", "c"}, []string{"a/b/x.txt", "y.txt"}},
+		{[]string{"missing"}, nil, nil},
+	}
+	for _, c := range cases {
+		filter, err := NewPathFilter(c.include, c.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outDir := filepath.Join(t.TempDir(), "out")
+		_, err = Restore(context.Background(), &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2, Filter: filter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := listFiles(t, outDir)
+		if len(got) != len(c.expect) {
+			t.Fatalf("include %v exclude %v: restored %v, expect %v", c.include, c.exclude, got, c.expect)
+		}
+		for i, p := range got {
+			if p != c.expect[i] {
+				t.Fatalf("include %v exclude %v: restored %v, expect %v", c.include, c.exclude, got, c.expect)
+			}
+			restored, _ := os.ReadFile(filepath.Join(outDir, p))
+			origin, _ := os.ReadFile(filepath.Join(dataDir, p))
+			if !bytes.Equal(restored, origin) {
+				t.Fatalf("content of %s differs", p)
+			}
+		}
+	}
+
+	if _, err := NewPathFilter([]string{"a/["}, nil); err == nil {
+		t.Fatal("expect an error for a bad pattern")
+	}
+}
+
+func TestRestoreCarIndex(t *testing.T) {
+	carDir := t.TempDir()
+	dataDir := chunkTestData(t, carDir, 64<<10)
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	carIndexes := func() (cars, indexes int) {
+		entries, err := os.ReadDir(carDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if IsCarIndex(e.Name()) {
+				indexes++
+			} else if !isCarDirSidecar(e.Name()) {
+				cars++
+			}
+		}
+		return cars, indexes
+	}
+
+	cases := []struct {
+		cacheIndex, dryRun bool
+	}{
+		{false, false},
+		{true, true},
+		{true, false},
+		// the second restore reads the cached indexes
+		{true, false},
+	}
+	for _, c := range cases {
+		outDir := filepath.Join(t.TempDir(), "out")
+		params := &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2, CacheIndex: c.cacheIndex, DryRun: c.dryRun}
+		if _, err := Restore(context.Background(), params); err != nil {
+			t.Fatal(err)
+		}
+		// the indexes of a restore are removed with their temp dir
+		if left, _ := os.ReadDir(tmpDir); len(left) != 0 {
+			t.Fatalf("cache index %t dry run %t: %d entries left in the temp dir", c.cacheIndex, c.dryRun, len(left))
+		}
+		cars, indexes := carIndexes()
+		expect := 0
+		if c.cacheIndex && !c.dryRun {
+			expect = cars
+		}
+		if cars == 0 || indexes != expect {
+			t.Fatalf("cache index %t dry run %t: %d indexes for %d CAR files, expect %d", c.cacheIndex, c.dryRun, indexes, cars, expect)
+		}
+		if c.dryRun {
+			continue
+		}
+		for _, p := range listFiles(t, dataDir) {
+			restored, _ := os.ReadFile(filepath.Join(outDir, p))
+			origin, _ := os.ReadFile(filepath.Join(dataDir, p))
+			if !bytes.Equal(restored, origin) {
+				t.Fatalf("content of %s differs", p)
+			}
+		}
+	}
+}
+
+func TestRestoreSplitPartOffsets(t *testing.T) {
+	carDir := t.TempDir()
+	dataDir := chunkTestData(t, carDir, 64<<10)
+	big, err := os.ReadFile(filepath.Join(dataDir, "a/big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// drop the CAR holding the second part, the others still go to their offsets
+	var missing *fsSource
+	cars, _ := filepath.Glob(filepath.Join(carDir, "*.car"))
+	for _, carPath := range cars {
+		index, err := ReadSliceIndex(carPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var walk func(nd *fsNode)
+		walk = func(nd *fsNode) {
+			for i := range nd.Link {
+				if nd.Link[i].Name == "big.bin.00000001" {
+					missing = nd.Link[i].Src
+					os.Remove(carPath)
+				}
+				walk(&nd.Link[i])
+			}
+		}
+		walk(index.Root)
+	}
+	if missing == nil {
+		t.Fatal("big.bin.00000001 not found")
+	}
+
+	outDir := filepath.Join(t.TempDir(), "out")
+	report, err := Restore(context.Background(), &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := os.ReadFile(filepath.Join(outDir, "a/big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != len(big) {
+		t.Fatalf("restored %d bytes, expect %d", len(restored), len(big))
+	}
+	end := missing.Offset + missing.Length
+	if !bytes.Equal(restored[:missing.Offset], big[:missing.Offset]) || !bytes.Equal(restored[end:], big[end:]) {
+		t.Fatal("parts are not restored at their offsets")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(outDir, "a/big.bin.*")); len(matches) > 0 {
+		t.Fatalf("unexpected part files %v", matches)
+	}
+
+	if len(report.Incomplete) != 1 || report.Incomplete[0].Path != "a/big.bin" {
+		t.Fatalf("unexpected incomplete files %+v", report.Incomplete)
+	}
+	parts := report.Incomplete[0].Missing
+	if len(parts) != 1 || parts[0].Part != 1 || parts[0].Offset != missing.Offset || parts[0].Length != missing.Length {
+		t.Fatalf("unexpected missing parts %+v", parts)
+	}
+	if parts[0].PayloadCid == "" || parts[0].CarFile == "" {
+		t.Fatalf("missing slice of part 1 is not reported: %+v", parts[0])
+	}
+}
+
+func TestRestoreUnsafeEntries(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdtest.Mock()
+	add := func(nd ipld.Node) ipld.Node {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		return nd
+	}
+	file := add(merkledag.NodeWithData(unixfs.FilePBData([]byte("evil"), 4)))
+	symlink := func(target string) ipld.Node {
+		data, err := unixfs.SymlinkData(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return add(merkledag.NodeWithData(data))
+	}
+	dirOf := func(name string, child ipld.Node) ipld.Node {
+		dir := uio.NewDirectory(dserv)
+		if err := dir.AddChild(ctx, name, child); err != nil {
+			t.Fatal(err)
+		}
+		nd, err := dir.GetNode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return add(nd)
+	}
+
+	cases := map[string]ipld.Node{
+		"dotdot name":       dirOf("..", dirOf("evil", file)),
+		"slash name":        dirOf("../evil", file),
+		"absolute symlink":  dirOf("link", symlink("/etc/passwd")),
+		"escaping symlink":  dirOf("sub", dirOf("link", symlink("../../evil"))),
+		"stepback symlink":  dirOf("link", symlink("sub/../../evil")),
+		"depth limit":       dirOf("a", dirOf("b", dirOf("c", file))),
+		"bytes limit":       dirOf("big", add(merkledag.NodeWithData(unixfs.FilePBData(make([]byte, 100), 100)))),
+		"safe symlink kept": dirOf("sub", dirOf("link", symlink("../other"))),
+	}
+	limits := RestoreLimits{MaxDepth: 2, MaxBytes: 64}
+	for name, root := range cases {
+		base := t.TempDir()
+		outDir := filepath.Join(base, "out")
+		nd, err := unixfile.NewUnixfsFile(ctx, dserv, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &restorer{params: &RestoreParams{OutputDir: outDir, Limits: limits}}
+		err = r.writeNode(nd, outDir, "")
+		if name == "safe symlink kept" {
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Fatalf("%s: expect an error", name)
+		}
+		for _, p := range listFiles(t, base) {
+			if !strings.HasPrefix(p, "out/") || strings.HasSuffix(p, "link") || p == "out/big" {
+				t.Fatalf("%s: unexpected file written %s", name, p)
+			}
+		}
+	}
+}
+
+func TestRestoreSymlinkParent(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdtest.Mock()
+	file := merkledag.NodeWithData(unixfs.FilePBData([]byte("evil"), 4))
+	data, err := unixfs.SymlinkData("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	symlink := merkledag.NodeWithData(data)
+	dir := uio.NewDirectory(dserv)
+	if err := dir.AddChild(ctx, "f", file); err != nil {
+		t.Fatal(err)
+	}
+	dirNode, err := dir.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []ipld.Node{file, symlink, dirNode} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a directory of the output replaced by a symlink does not lead whole files, symlinks and
+	// directories out of it
+	for name, root := range map[string]ipld.Node{"file": file, "symlink": symlink, "dir": dirNode} {
+		base := t.TempDir()
+		outDir, outside := filepath.Join(base, "out"), filepath.Join(base, "outside")
+		for _, d := range []string{outDir, outside} {
+			if err := os.Mkdir(d, 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink(outside, filepath.Join(outDir, "sub")); err != nil {
+			t.Fatal(err)
+		}
+		nd, err := unixfile.NewUnixfsFile(ctx, dserv, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &restorer{params: &RestoreParams{OutputDir: outDir}}
+		if err := r.writeNode(nd, filepath.Join(outDir, "sub", "x"), "sub/x"); err == nil {
+			t.Fatalf("%s: expect an error", name)
+		}
+		if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+			t.Fatalf("%s: %d entries written out of the output", name, len(entries))
+		}
+	}
+}
+
+func TestRestoreConflict(t *testing.T) {
+	carDir := t.TempDir()
+	dataDir := chunkTestData(t, carDir, 64<<10)
+	ctx := context.Background()
+
+	prepare := func() string {
+		outDir := filepath.Join(t.TempDir(), "out")
+		if err := os.MkdirAll(filepath.Join(outDir, "a"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{"y.txt", "a/big.bin"} {
+			if err := os.WriteFile(filepath.Join(outDir, p), []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return outDir
+	}
+	content := func(p string) string {
+		data, _ := os.ReadFile(p)
+		return string(data)
+	}
+	big := content(filepath.Join(dataDir, "a/big.bin"))
+
+	outDir := prepare()
+	report, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2, OnConflict: ConflictRename, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conflicts int
+	for _, e := range report.Entries {
+		if e.Conflict() {
+			conflicts++
+		}
+	}
+	if len(report.Entries) != 4 || conflicts != 2 {
+		t.Fatalf("unexpected dry run entries %+v", report.Entries)
+	}
+	if got := listFiles(t, outDir); len(got) != 2 {
+		t.Fatalf("dry run wrote %v", got)
+	}
+
+	outDir = prepare()
+	if _, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2, OnConflict: ConflictSkip}); err != nil {
+		t.Fatal(err)
+	}
+	if content(filepath.Join(outDir, "y.txt")) != "old" || content(filepath.Join(outDir, "a/big.bin")) != "old" ||
+		content(filepath.Join(outDir, "a/b/x.txt")) != "hello" {
+		t.Fatal("skip policy overwrote existing files")
+	}
+
+	outDir = prepare()
+	if _, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2, OnConflict: ConflictRename}); err != nil {
+		t.Fatal(err)
+	}
+	if content(filepath.Join(outDir, "y.txt")) != "old" || content(filepath.Join(outDir, "y (1).txt")) != "world" ||
+		content(filepath.Join(outDir, "a/big (1).bin")) != big {
+		t.Fatal("rename policy did not restore to a new name")
+	}
+
+	outDir = prepare()
+	if _, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2, OnConflict: ConflictFail}); !errors.Is(err, ErrRestoreConflict) {
+		t.Fatalf("expect a conflict error, got %v", err)
+	}
+}
+
+// leafCars maps every leaf of the graph chunked to carDir to the CAR file holding it
+func leafCars(t *testing.T, carDir string) map[string]string {
+	t.Helper()
+	carOf := make(map[string]string)
+	cars, _ := filepath.Glob(filepath.Join(carDir, "*.car"))
+	for _, carPath := range cars {
+		index, err := ReadSliceIndex(carPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var walk func(nd *fsNode, dagPath string)
+		walk = func(nd *fsNode, dagPath string) {
+			for i := range nd.Link {
+				p := path.Join(dagPath, nd.Link[i].Name)
+				if len(nd.Link[i].Link) == 0 {
+					carOf[p] = carPath
+				}
+				walk(&nd.Link[i], p)
+			}
+		}
+		walk(index.Root, "")
+	}
+	return carOf
+}
+
+func TestRestoreResume(t *testing.T) {
+	carDir := t.TempDir()
+	dataDir := chunkTestData(t, carDir, 64<<10)
+	ctx := context.Background()
+
+	carOf := leafCars(t, carDir)
+
+	// the first restore is interrupted before the CAR holding the second part of big.bin
+	interrupted := carOf["a/big.bin.00000001"]
+	aside := filepath.Join(t.TempDir(), filepath.Base(interrupted))
+	if err := os.Rename(interrupted, aside); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(t.TempDir(), "out")
+	report, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Complete() {
+		t.Fatal("expect an incomplete restore")
+	}
+	if _, err := os.Stat(filepath.Join(outDir, RestoreStateFile)); err != nil {
+		t.Fatal("restore state is not saved, ", err)
+	}
+	if err := os.Rename(aside, interrupted); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file of a completed CAR is not written again by the resumed restore
+	skipped := "y.txt"
+	if carOf[skipped] == interrupted {
+		skipped = "a/b/x.txt"
+	}
+	if err := os.Remove(filepath.Join(outDir, skipped)); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, _ := NewPathFilter([]string{"a"}, nil)
+	if _, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2, Filter: filter, Resume: true}); err == nil {
+		t.Fatal("expect an error resuming with another filter")
+	}
+	report, err = Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2, Resume: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Complete() {
+		t.Fatalf("unexpected incomplete files %+v", report.Incomplete)
+	}
+	restored, _ := os.ReadFile(filepath.Join(outDir, "a/big.bin"))
+	big, _ := os.ReadFile(filepath.Join(dataDir, "a/big.bin"))
+	if !bytes.Equal(restored, big) {
+		t.Fatal("content of a/big.bin differs")
+	}
+	if _, err := os.Stat(filepath.Join(outDir, skipped)); !os.IsNotExist(err) {
+		t.Fatalf("%s of a completed CAR is restored again", skipped)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, RestoreStateFile)); !os.IsNotExist(err) {
+		t.Fatal("restore state is not removed once complete")
+	}
+}
+
+func TestRestoreKeepGoing(t *testing.T) {
+	carDir := t.TempDir()
+	chunkTestData(t, carDir, 64<<10)
+	ctx := context.Background()
+
+	carOf := leafCars(t, carDir)
+	// break a CAR file which does not hold y.txt
+	var broken string
+	for _, carPath := range carOf {
+		if carPath != carOf["y.txt"] {
+			broken = carPath
+		}
+	}
+	if broken == "" {
+		t.Fatal("y.txt is expected in another CAR than the rest of the graph")
+	}
+	if err := os.WriteFile(broken, []byte("not a car"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keepGoing := range []bool{false, true} {
+		outDir := t.TempDir()
+		report, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 1, KeepGoing: keepGoing})
+		var rerr *RestoreError
+		if !errors.As(err, &rerr) {
+			t.Fatalf("keep-going %v: expect a RestoreError, got %v", keepGoing, err)
+		}
+		if len(rerr.Failures) == 0 || rerr.Failures[0].Car != broken {
+			t.Fatalf("keep-going %v: unexpected failures %+v", keepGoing, rerr.Failures)
+		}
+		if !strings.Contains(err.Error(), "in 1 of") {
+			t.Fatalf("keep-going %v: unexpected error %q", keepGoing, err)
+		}
+		if report == nil || len(report.Failures) != len(rerr.Failures) {
+			t.Fatalf("keep-going %v: failures are not in the report", keepGoing)
+		}
+		if !keepGoing {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(outDir, "y.txt"))
+		if err != nil || string(data) != "world" {
+			t.Fatalf("y.txt of another CAR is not restored: %q %v", data, err)
+		}
+	}
+}
+
+func TestRestoreVerify(t *testing.T) {
+	ctx := context.Background()
+	for _, algo := range []string{ChecksumSHA256, ChecksumBLAKE3} {
+		carDir := t.TempDir()
+		chunkTestDataWith(t, carDir, 64<<10, algo, nil)
+		report, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: t.TempDir(), Parallel: 2, Verify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 3 small files, the 4 parts of a/big.bin and the whole of it
+		if report.Verified != 8 || report.Unverified != 0 || len(report.Mismatches) != 0 {
+			t.Fatalf("%s: unexpected verify result %d %d %+v", algo, report.Verified, report.Unverified, report.Mismatches)
+		}
+	}
+
+	// a checksum that does not match the restored content
+	carDir := t.TempDir()
+	chunkTestData(t, carDir, 64<<10)
+	carOf := leafCars(t, carDir)
+	index, err := ReadSliceIndex(carOf["y.txt"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	walkSources(index.Root, "", func(dagPath string, src *fsSource) {
+		if dagPath == "y.txt" {
+			src.Checksum = "sha256:" + strings.Repeat("0", 64)
+		}
+	})
+	if err := WriteSliceIndex(carOf["y.txt"], index); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: t.TempDir(), Parallel: 2, Verify: true})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expect a checksum mismatch, got %v", err)
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0].Path != "y.txt" || report.Verified != 7 {
+		t.Fatalf("unexpected mismatches %+v", report.Mismatches)
+	}
+}
+
+func TestRestorePieceFiles(t *testing.T) {
+	carDir := t.TempDir()
+	dataDir := chunkTestData(t, carDir, 64<<10)
+	ctx := context.Background()
+
+	// the padded piece files handed to storage providers, named by their piece CID
+	cars, _ := filepath.Glob(filepath.Join(carDir, "*.car"))
+	for _, carPath := range cars {
+		res, err := CalcCommP(ctx, carPath, true, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		piecePath := filepath.Join(carDir, res.Root.String())
+		if fi, err := os.Stat(piecePath); err != nil || fi.Size() != int64(res.Size) {
+			t.Fatalf("piece file %s is not padded to %d bytes", piecePath, res.Size)
+		}
+		if _, err := ReadSliceIndex(piecePath); err != nil {
+			t.Fatal("slice index does not follow the rename, ", err)
+		}
+		if _, err := Import(ctx, piecePath, blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filter, _ := NewPathFilter([]string{"a"}, nil)
+	for _, f := range []*PathFilter{nil, filter} {
+		outDir := filepath.Join(t.TempDir(), "out")
+		report, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2, Filter: f})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Complete() {
+			t.Fatalf("unexpected incomplete files %+v", report.Incomplete)
+		}
+		for _, p := range listFiles(t, outDir) {
+			restored, _ := os.ReadFile(filepath.Join(outDir, p))
+			origin, _ := os.ReadFile(filepath.Join(dataDir, p))
+			if !bytes.Equal(restored, origin) {
+				t.Fatalf("content of %s differs", p)
+			}
+		}
+		if f == nil && len(listFiles(t, outDir)) != 4 {
+			t.Fatalf("restored %v", listFiles(t, outDir))
+		}
+	}
+}
+
+func TestRestoreTar(t *testing.T) {
+	carDir := t.TempDir()
+	dataDir := chunkTestData(t, carDir, 64<<10)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	if err := RestoreTar(ctx, &RestoreParams{CarPath: carDir}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"a/", "a/b/", "a/b/x.txt", "a/big.bin", "c/", "c/empty.md", "y.txt"}
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, _ := io.ReadAll(tr)
+		origin, _ := os.ReadFile(filepath.Join(dataDir, hdr.Name))
+		if !bytes.Equal(content, origin) {
+			t.Fatalf("content of %s differs", hdr.Name)
+		}
+	}
+	if strings.Join(names, ",") != strings.Join(expect, ",") {
+		t.Fatalf("tar entries %v, expect %v", names, expect)
+	}
+
+	var again bytes.Buffer
+	if err := RestoreTar(ctx, &RestoreParams{CarPath: carDir}, &again); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), again.Bytes()) {
+		t.Fatal("tar stream is not deterministic")
+	}
+
+	// the limits of the tree are checked before anything is written
+	var total int64
+	for _, p := range []string{"a/b/x.txt", "a/big.bin", "c/empty.md", "y.txt"} {
+		fi, err := os.Stat(filepath.Join(dataDir, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += fi.Size()
+	}
+	for _, limits := range []RestoreLimits{{MaxBytes: total - 1}, {MaxEntries: int64(len(expect)) - 1}, {MaxDepth: 2}} {
+		var limited bytes.Buffer
+		err := RestoreTar(ctx, &RestoreParams{CarPath: carDir, Limits: limits}, &limited)
+		if !errors.Is(err, ErrRestoreLimit) || limited.Len() != 0 {
+			t.Fatalf("limits %+v: expect a limit error and no output, got %v and %d bytes", limits, err, limited.Len())
+		}
+	}
+	var limited bytes.Buffer
+	limits := RestoreLimits{MaxBytes: total, MaxEntries: int64(len(expect)), MaxDepth: 3}
+	if err := RestoreTar(ctx, &RestoreParams{CarPath: carDir, Limits: limits}, &limited); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), limited.Bytes()) {
+		t.Fatal("tar stream within the limits differs")
+	}
+	if err := RestoreTar(ctx, &RestoreParams{CarPath: carDir, KeepGoing: true}, io.Discard); err == nil {
+		t.Fatal("expect keep going to be rejected with tar")
+	}
+
+	// mode and mtime are optional fields of the UnixFS data
+	data := unixfs.FilePBData([]byte("hi"), 2)
+	data = protowire.AppendTag(data, 7, protowire.VarintType)
+	data = protowire.AppendVarint(data, 0o100640)
+	var mtime []byte
+	mtime = protowire.AppendTag(mtime, 1, protowire.VarintType)
+	mtime = protowire.AppendVarint(mtime, 1700000000)
+	data = protowire.AppendTag(data, 8, protowire.BytesType)
+	data = protowire.AppendBytes(data, mtime)
+	mode, mt := unixfsMeta(merkledag.NodeWithData(data))
+	if mode != 0o640 || !mt.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("unexpected mode %o mtime %s", mode, mt)
+	}
+	if mode, _ := unixfsMeta(merkledag.NodeWithData(unixfs.FilePBData([]byte("hi"), 2))); mode != -1 {
+		t.Fatalf("unexpected mode %o", mode)
+	}
+}
+
+func TestRestoreForeignCars(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdtest.Mock()
+	carDir := t.TempDir()
+	writeCar := func(name string, roots ...cid.Cid) string {
+		carPath := filepath.Join(carDir, name)
+		f, err := os.Create(carPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := car.WriteCar(ctx, dserv, roots, f); err != nil {
+			t.Fatal(err)
+		}
+		return carPath
+	}
+
+	// a CIDv1 file with raw leaves in a HAMT directory, next to a CIDv0 file root, as a CARv2
+	big := make([]byte, 300000)
+	rand.New(rand.NewSource(1)).Read(big)
+	db, err := (&helpers.DagBuilderParams{
+		Dagserv:    dserv,
+		Maxlinks:   helpers.DefaultLinksPerBlock,
+		RawLeaves:  true,
+		CidBuilder: merkledag.V1CidPrefix(),
+	}).New(chunker.NewSizeSplitter(bytes.NewReader(big), 64<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bigNode, err := balanced.Layout(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small := merkledag.NodeWithData(unixfs.FilePBData([]byte("hello"), 5))
+	if err := dserv.Add(ctx, small); err != nil {
+		t.Fatal(err)
+	}
+	defer func(size int) { uio.HAMTShardingSize = size }(uio.HAMTShardingSize)
+	uio.HAMTShardingSize = 1
+	hamt := uio.NewDirectory(dserv)
+	hamt.SetCidBuilder(merkledag.V1CidPrefix())
+	for name, nd := range map[string]ipld.Node{"big.bin": bigNode, "x.txt": small} {
+		if err := hamt.AddChild(ctx, name, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hamtNode, err := hamt.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.Add(ctx, hamtNode); err != nil {
+		t.Fatal(err)
+	}
+	if fsn, err := unixfs.ExtractFSNode(hamtNode); err != nil || fsn.Type() != unixfs.THAMTShard {
+		t.Fatal("expect a HAMT directory")
+	}
+	fileRoot := merkledag.NodeWithData(unixfs.FilePBData([]byte("root file"), 9))
+	if err := dserv.Add(ctx, fileRoot); err != nil {
+		t.Fatal(err)
+	}
+	multi := writeCar("multi.v1", hamtNode.Cid(), fileRoot.Cid())
+	if err := carv2.WrapV1File(multi, filepath.Join(carDir, "multi.car")); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(multi)
+
+	// a single CIDv0 directory root is restored into the output directory itself
+	uio.HAMTShardingSize = 0
+	writeCar("single.car", unixfsDir(t, dserv, "y.txt", merkledag.NodeWithData(unixfs.FilePBData([]byte("world"), 5))).Cid())
+
+	expect := map[string][]byte{
+		hamtNode.Cid().String() + "/big.bin": big,
+		hamtNode.Cid().String() + "/x.txt":   []byte("hello"),
+		fileRoot.Cid().String():              []byte("root file"),
+		"y.txt":                              []byte("world"),
+	}
+	outDir := filepath.Join(t.TempDir(), "out")
+	if _, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := listFiles(t, outDir); len(got) != len(expect) {
+		t.Fatalf("restored %v", got)
+	}
+	var buf bytes.Buffer
+	if err := RestoreTar(ctx, &RestoreParams{CarPath: carDir}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := OpenCarSet(carDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	for p, data := range expect {
+		restored, _ := os.ReadFile(filepath.Join(outDir, p))
+		var cat bytes.Buffer
+		if err := cs.Cat(ctx, p, 0, -1, &cat); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(restored, data) || !bytes.Equal(cat.Bytes(), data) {
+			t.Fatalf("content of %s differs", p)
+		}
+	}
+
+	// roots that are not UnixFS are rejected with the codec they use
+	cborDir := t.TempDir()
+	blk := blocks.NewBlock([]byte{0xa0})
+	c := cid.NewCidV1(cid.DagCBOR, blk.Cid().Hash())
+	f, err := os.Create(filepath.Join(cborDir, "cbor.car"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{c}, Version: 1}, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := carutil.LdWrite(f, c.Bytes(), blk.RawData()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := RestoreTar(ctx, &RestoreParams{CarPath: cborDir}, io.Discard); err == nil || !strings.Contains(err.Error(), "dag-cbor") {
+		t.Fatalf("expect an error naming the codec, got %v", err)
+	}
+}
+
+// TestRestoreForeignSplitParts restores CAR files written by other tools, without sidecars,
+// holding names that look like split parts
+func TestRestoreForeignSplitParts(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdtest.Mock()
+	cases := []struct {
+		files   map[string]string
+		expect  map[string]string
+		missing map[string][]MissingPart
+	}{
+		// a date suffix leaves one run of unknown parts, not one per index
+		{
+			files:  map[string]string{"backup.00000000": "first", "backup.20230101": "dated"},
+			expect: map[string]string{"backup": "first", "backup.20230101": "dated"},
+			missing: map[string][]MissingPart{"backup": {
+				{Part: 1, Offset: 5, Length: -1},
+				{Part: 20230101, Offset: -1, Length: 5, CarFile: "foreign.car"},
+			}},
+		},
+		// without part 0 these are files of their own, a date stamp included
+		{
+			files:  map[string]string{"backup.00000005": "five", "backup.20230101": "dated"},
+			expect: map[string]string{"backup.00000005": "five", "backup.20230101": "dated"},
+		},
+	}
+	for _, c := range cases {
+		dir := uio.NewDirectory(dserv)
+		for name, data := range c.files {
+			nd := merkledag.NodeWithData(unixfs.FilePBData([]byte(data), uint64(len(data))))
+			if err := dserv.Add(ctx, nd); err != nil {
+				t.Fatal(err)
+			}
+			if err := dir.AddChild(ctx, name, nd); err != nil {
+				t.Fatal(err)
+			}
+		}
+		root, err := dir.GetNode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dserv.Add(ctx, root); err != nil {
+			t.Fatal(err)
+		}
+		carDir := t.TempDir()
+		f, err := os.Create(filepath.Join(carDir, "foreign.car"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := car.WriteCar(ctx, dserv, []cid.Cid{root.Cid()}, f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		outDir := filepath.Join(t.TempDir(), "out")
+		report, err := Restore(ctx, &RestoreParams{CarPath: carDir, OutputDir: outDir, Parallel: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the state of an incomplete restore is kept for --resume
+		got := make(map[string]string)
+		for _, p := range listFiles(t, outDir) {
+			if p != RestoreStateFile {
+				data, _ := os.ReadFile(filepath.Join(outDir, p))
+				got[p] = string(data)
+			}
+		}
+		missing := make(map[string][]MissingPart)
+		for _, f := range report.Incomplete {
+			for _, m := range f.Missing {
+				missing[f.Path] = append(missing[f.Path], *m)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.expect) {
+			t.Fatalf("%v: restored %v", c.files, got)
+		}
+		if fmt.Sprintf("%+v", missing) != fmt.Sprintf("%+v", c.missing) {
+			t.Fatalf("%v: missing parts %+v, expect %+v", c.files, missing, c.missing)
+		}
+	}
+}
+
+func unixfsDir(t *testing.T, dserv ipld.DAGService, name string, child ipld.Node) ipld.Node {
+	t.Helper()
+	ctx := context.Background()
+	if err := dserv.Add(ctx, child); err != nil {
+		t.Fatal(err)
+	}
+	dir := uio.NewDirectory(dserv)
+	if err := dir.AddChild(ctx, name, child); err != nil {
+		t.Fatal(err)
+	}
+	nd, err := dir.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	return nd
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.bin.00000000", "hello ")
+	write("a.bin.00000001", "world")
+	// b.bin misses its second part, the third part of c.bin cannot be read
+	write("b.bin.00000000", "b0")
+	write("b.bin.00000002", "b2")
+	write("c.bin.00000000", "c0")
+	if err := os.Mkdir(filepath.Join(dir, "c.bin.00000001"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Merge(dir, 2)
+	if err == nil || !strings.Contains(err.Error(), "merge failed: 2 errors in 3 files") {
+		t.Fatalf("expect one error for b.bin and one for c.bin, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "b.bin is incomplete, part 1 is missing") ||
+		strings.Count(err.Error(), "c.bin.00000001") != 1 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.bin")); string(data) != "hello world" {
+		t.Fatalf("unexpected a.bin %q", data)
+	}
+	// the part that failed is kept
+	if _, err := os.Stat(filepath.Join(dir, "c.bin.00000001")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.bin.00000000")); !os.IsNotExist(err) {
+		t.Fatalf("expect the merged parts removed, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package graphsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ChecksumMismatch is a restored file, or a part of a restored split file, whose content differs
+// from the checksum recorded at chunk time
+type ChecksumMismatch struct {
+	// Path is the path inside the graph of the file or of the part
+	Path string `json:"path"`
+	// File is the restored file, Offset and Length the range of it that was checked
+	File     string `json:"file"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual,omitempty"`
+	// Error tells why the range could not be read, Actual is empty then
+	Error string `json:"error,omitempty"`
+}
+
+// checksumCheck is a range of a restored file to check against its recorded checksum
+type checksumCheck struct {
+	dagPath  string
+	fpath    string
+	offset   int64
+	length   int64
+	expected string
+}
+
+func (r *restorer) setWritten(dagPath, fpath string) {
+	if !r.params.Verify {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.written == nil {
+		r.written = make(map[string]string)
+	}
+	r.written[dagPath] = fpath
+}
+
+// verify recomputes the checksums of the files restored from cars, and of every part of the split
+// files along with the whole file once it is complete. The counts and mismatches go to report.
+func (r *restorer) verify(ctx context.Context, cars []string, report *RestoreReport) {
+	incomplete := make(map[string]bool)
+	for _, f := range report.Incomplete {
+		incomplete[f.Path] = true
+	}
+	seen := make(map[string]bool)
+	var checks []*checksumCheck
+	add := func(c *checksumCheck) {
+		key := fmt.Sprintf("%s:%d", c.dagPath, c.offset)
+		if !seen[key] {
+			seen[key] = true
+			checks = append(checks, c)
+		}
+	}
+	for _, carPath := range cars {
+		index, err := ReadSliceIndex(carPath)
+		if err != nil || index.Root == nil {
+			log.Warnf("no checksums for %s, its files are not verified", carPath)
+			continue
+		}
+		walkSources(index.Root, "", func(dagPath string, src *fsSource) {
+			if sf, part, base := r.plan.part(dagPath); sf != nil {
+				if sf.skip || part == nil || !part.done {
+					return
+				}
+				if src.Checksum == "" {
+					report.Unverified++
+					return
+				}
+				add(&checksumCheck{dagPath, sf.fpath, src.Offset, src.Length, src.Checksum})
+				if src.FileChecksum != "" && !incomplete[base] {
+					add(&checksumCheck{base, sf.fpath, 0, src.Size, src.FileChecksum})
+				}
+				return
+			}
+			fpath := r.restoredFile(dagPath)
+			if fpath == "" {
+				return
+			}
+			if src.Checksum == "" {
+				report.Unverified++
+				return
+			}
+			add(&checksumCheck{dagPath, fpath, 0, src.Length, src.Checksum})
+		})
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	checkCh := make(chan *checksumCheck)
+	for i := 0; i < r.params.Parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range checkCh {
+				m := c.run()
+				mu.Lock()
+				if m != nil {
+					report.Mismatches = append(report.Mismatches, m)
+				} else {
+					report.Verified++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, c := range checks {
+		if ctx.Err() != nil {
+			break
+		}
+		checkCh <- c
+	}
+	close(checkCh)
+	wg.Wait()
+	sort.Slice(report.Mismatches, func(i, j int) bool {
+		mi, mj := report.Mismatches[i], report.Mismatches[j]
+		return mi.Path < mj.Path || mi.Path == mj.Path && mi.Offset < mj.Offset
+	})
+}
+
+// restoredFile returns where the whole file at dagPath was restored, empty when it was not. A resumed
+// restore did not write the files of the CAR files completed before, they are checked where they are.
+func (r *restorer) restoredFile(dagPath string) string {
+	r.mu.Lock()
+	fpath, ok := r.written[dagPath]
+	r.mu.Unlock()
+	if ok || !r.params.Resume || !r.params.Filter.Match(dagPath) {
+		return fpath
+	}
+	fpath = filepath.Join(r.params.OutputDir, filepath.FromSlash(dagPath))
+	if fi, err := os.Lstat(fpath); err != nil || !fi.Mode().IsRegular() {
+		return ""
+	}
+	return fpath
+}
+
+func (c *checksumCheck) run() *ChecksumMismatch {
+	actual, err := checksumRange(c.fpath, c.offset, c.length, c.expected)
+	if err == nil && actual == c.expected {
+		return nil
+	}
+	m := &ChecksumMismatch{Path: c.dagPath, File: c.fpath, Offset: c.offset, Length: c.length, Expected: c.expected, Actual: actual}
+	if err != nil {
+		m.Error = err.Error()
+		log.Errorf("failed to verify %s: %s", c.dagPath, err)
+	} else {
+		log.Errorf("%s: checksum mismatch, expect %s, got %s", c.dagPath, c.expected, actual)
+	}
+	return m
+}
+
+// walkSources calls fn with every leaf of the slice index that has a source, empty directories have none
+func walkSources(nd *fsNode, dagPath string, fn func(dagPath string, src *fsSource)) {
+	for i := range nd.Link {
+		ln := &nd.Link[i]
+		if checkEntryName(ln.Name) != nil {
+			continue
+		}
+		childPath := path.Join(dagPath, ln.Name)
+		if len(ln.Link) > 0 {
+			walkSources(ln, childPath, fn)
+		} else if ln.Src != nil {
+			fn(childPath, ln.Src)
+		}
+	}
+}
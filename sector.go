@@ -0,0 +1,81 @@
+package graphsplit
+
+import (
+	"fmt"
+
+	"github.com/docker/go-units"
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// DefaultSectorSize is the sector size the pieces are made for when none is set
+const DefaultSectorSize = 32 * Gib
+
+// sealProofs are the seal proofs whose sector sizes a piece can be made for
+var sealProofs = []abi.RegisteredSealProof{
+	abi.RegisteredSealProof_StackedDrg2KiBV1_1,
+	abi.RegisteredSealProof_StackedDrg8MiBV1_1,
+	abi.RegisteredSealProof_StackedDrg512MiBV1_1,
+	abi.RegisteredSealProof_StackedDrg32GiBV1_1,
+	abi.RegisteredSealProof_StackedDrg64GiBV1_1,
+}
+
+// ParseSectorSize parses a sector size such as 512MiB or 64GiB, the default sector size when empty
+func ParseSectorSize(s string) (int64, error) {
+	if s == "" {
+		return DefaultSectorSize, nil
+	}
+	size, err := units.RAMInBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sector size %q: %w", s, err)
+	}
+	return size, CheckSectorSize(size)
+}
+
+// CheckSectorSize checks that size is the sector size of a Filecoin seal proof
+func CheckSectorSize(size int64) error {
+	for _, proof := range sealProofs {
+		if ss, err := proof.SectorSize(); err == nil && int64(ss) == size {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported sector size %d, expect one of 2KiB, 8MiB, 512MiB, 32GiB, 64GiB", size)
+}
+
+// SectorCapacity returns the largest piece of a sector, in unpadded bytes: the most CAR payload it holds
+func SectorCapacity(sectorSize int64) int64 {
+	return int64(abi.PaddedPieceSize(sectorSize).Unpadded())
+}
+
+// checkPieceFits returns an error when the piece of a CAR payload of payloadSize bytes is larger than the sector
+func checkPieceFits(payloadSize, sectorSize int64) error {
+	if payloadSize > SectorCapacity(sectorSize) {
+		return fmt.Errorf("CAR payload of %d bytes does not fit a %s sector, which holds %d bytes",
+			payloadSize, units.BytesSize(float64(sectorSize)), SectorCapacity(sectorSize))
+	}
+	return nil
+}
+
+// ParsePadTo parses the piece size pieces are padded to, such as 32GiB, checked against the sector
+// size. It is 0 when empty, the pieces are padded to their own size then.
+func ParsePadTo(s string, sectorSize int64) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	size, err := units.RAMInBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid piece size %q: %w", s, err)
+	}
+	return size, CheckPadTo(size, sectorSize)
+}
+
+// CheckPadTo checks that padTo is a piece size, a power of two from 128 bytes, that fits the sector
+func CheckPadTo(padTo, sectorSize int64) error {
+	if err := abi.PaddedPieceSize(padTo).Validate(); err != nil {
+		return fmt.Errorf("invalid piece size %d to pad to: %w", padTo, err)
+	}
+	if padTo > sectorSize {
+		return fmt.Errorf("piece size %s to pad to is larger than the %s sector",
+			units.BytesSize(float64(padTo)), units.BytesSize(float64(sectorSize)))
+	}
+	return nil
+}
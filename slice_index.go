@@ -0,0 +1,47 @@
+package graphsplit
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+const sliceIndexSuffix = ".index.json"
+
+// SliceIndex is the content of the <name>.index.json sidecar written next to every CAR file,
+// it holds the complete file tree of the graph slice
+type SliceIndex struct {
+	PayloadCid string  `json:"payload_cid"`
+	GraphName  string  `json:"graph_name"`
+	Root       *fsNode `json:"root"`
+}
+
+// SliceIndexPath returns the sidecar path of a CAR file, the .car suffix is not part of the name
+func SliceIndexPath(carPath string) string {
+	return strings.TrimSuffix(carPath, ".car") + sliceIndexSuffix
+}
+
+func IsSliceIndex(name string) bool {
+	return strings.HasSuffix(name, sliceIndexSuffix)
+}
+
+func WriteSliceIndex(carPath string, index *SliceIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(SliceIndexPath(carPath), data, 0o644)
+}
+
+// ReadSliceIndex loads the sidecar of a CAR file
+func ReadSliceIndex(carPath string) (*SliceIndex, error) {
+	data, err := os.ReadFile(SliceIndexPath(carPath))
+	if err != nil {
+		return nil, err
+	}
+	var index SliceIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
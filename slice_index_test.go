@@ -0,0 +1,140 @@
+package graphsplit
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSliceIndex(t *testing.T) {
+	carDir := t.TempDir()
+	dataDir := chunkTestData(t, carDir, 64<<10)
+	bigPath := filepath.Join(dataDir, "a/big.bin")
+	big, err := os.Stat(bigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(carDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []*fsNode
+	partPaths := make(map[*fsNode]string)
+	wholeFiles := make(map[string]*fsNode)
+	for _, e := range entries {
+		if isCarDirSidecar(e.Name()) {
+			continue
+		}
+		index, err := ReadSliceIndex(filepath.Join(carDir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index.PayloadCid+".car" != e.Name() || !strings.HasPrefix(index.GraphName, "test-") || index.Root == nil {
+			t.Fatalf("unexpected index of %s: %+v", e.Name(), index)
+		}
+		var walk func(nd *fsNode, dagPath string)
+		walk = func(nd *fsNode, dagPath string) {
+			if nd.Src != nil {
+				if nd.Src.Path == bigPath {
+					parts = append(parts, nd)
+					partPaths[nd] = dagPath
+				} else {
+					wholeFiles[dagPath] = nd
+				}
+			}
+			for i := range nd.Link {
+				walk(&nd.Link[i], path.Join(dagPath, nd.Link[i].Name))
+			}
+		}
+		walk(index.Root, "")
+	}
+
+	// the parts of the split file cover it in order, the checksum of the whole file is on the last one
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Src.Offset < parts[j].Src.Offset })
+	if len(parts) != 4 {
+		t.Fatalf("expect a/big.bin in 4 parts, got %d", len(parts))
+	}
+	var offset int64
+	for i, p := range parts {
+		if expect := splitPartName("a/big.bin", i); partPaths[p] != expect {
+			t.Fatalf("part %d is at %s, expect %s", i, partPaths[p], expect)
+		}
+		if p.Src.Size != big.Size() || p.Src.Offset != offset || p.Src.Length <= 0 || uint64(p.Src.Length) > p.Size ||
+			p.Hash == "" || p.Src.Checksum == "" || (p.Src.FileChecksum != "") != (i == len(parts)-1) {
+			t.Fatalf("unexpected part %d %+v", i, p.Src)
+		}
+		offset += p.Src.Length
+	}
+	if offset != big.Size() {
+		t.Fatalf("the parts cover %d of %d bytes", offset, big.Size())
+	}
+
+	for _, name := range []string{"a/b/x.txt", "y.txt", "c/empty.md"} {
+		nd, ok := wholeFiles[name]
+		if !ok {
+			t.Fatalf("%s is not in the indexes", name)
+		}
+		fi, err := os.Stat(filepath.Join(dataDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nd.Src.Path != filepath.Join(dataDir, name) || nd.Src.Size != fi.Size() || nd.Src.Offset != 0 || nd.Src.Length != fi.Size() {
+			t.Fatalf("unexpected source of %s: %+v", name, nd.Src)
+		}
+	}
+	if len(wholeFiles) != 3 {
+		t.Fatalf("expect 3 whole files, got %d", len(wholeFiles))
+	}
+}
+
+// countCallback only implements GraphBuildCallback, like the callbacks written before the slice index
+type countCallback struct {
+	slices atomic.Int32
+}
+
+func (cc *countCallback) OnSuccess(buf *Buffer, graphName, payloadCid, fsDetail string) {
+	cc.slices.Add(1)
+}
+
+func (cc *countCallback) OnError(err error) {
+	panic(err)
+}
+
+func TestGraphBuildCallbackWithoutIndex(t *testing.T) {
+	dataDir := t.TempDir()
+	for _, name := range []string{"x.txt", "y.txt"} {
+		if err := os.WriteFile(filepath.Join(dataDir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	carDir := t.TempDir()
+	cb := &countCallback{}
+	ef, err := NewExtraFile("", 0, 64<<10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Chunk(context.Background(), &ChunkParams{
+		ExpectSliceSize: 64 << 10,
+		TargetPath:      dataDir,
+		CarDir:          carDir,
+		GraphName:       "test",
+		Parallel:        1,
+		Cb:              cb,
+		Ef:              ef,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb.slices.Load() != 1 {
+		t.Fatalf("expect 1 slice, got %d", cb.slices.Load())
+	}
+	if entries, _ := os.ReadDir(carDir); len(entries) != 0 {
+		t.Fatalf("expect nothing written to the car dir, got %d entries", len(entries))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"math/rand"
@@ -17,6 +18,7 @@ import (
 	"time"
 
 	"github.com/filecoin-project/go-padreader"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
@@ -48,6 +50,10 @@ type Finfo struct {
 	Info      os.FileInfo
 	SeekStart int64
 	SeekEnd   int64
+
+	// checksums of the item and, for the last part of a split file, of the whole file
+	checksum     string
+	fileChecksum string
 }
 
 type SimpleFileInfo struct {
@@ -62,19 +68,40 @@ type SimplestFileInfo struct {
 
 // file system tree node
 type fsNode struct {
-	Name string
-	Hash string
-	Size uint64
-	Link []fsNode
+	Name string    `json:"name,omitempty"`
+	Hash string    `json:"cid"`
+	Size uint64    `json:"size"`
+	Src  *fsSource `json:"source,omitempty"`
+	Link []fsNode  `json:"links,omitempty"`
+}
+
+// fsSource locates the content of a file node in the original dataset,
+// for a split part Offset and Length give the byte range of the part
+type fsSource struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	// Checksum of the Offset and Length range, FileChecksum of the whole file is only recorded
+	// with the last part of a split file
+	Checksum     string `json:"checksum,omitempty"`
+	FileChecksum string `json:"file_checksum,omitempty"`
 }
 
 type FSBuilder struct {
-	root *dag.ProtoNode
-	ds   ipld.DAGService
+	root    *dag.ProtoNode
+	ds      ipld.DAGService
+	sources map[string]Finfo
 }
 
 func NewFSBuilder(root *dag.ProtoNode, ds ipld.DAGService) *FSBuilder {
-	return &FSBuilder{root, ds}
+	return &FSBuilder{root: root, ds: ds}
+}
+
+// WithSources sets the source files of the graph, keyed by their path inside the graph
+func (b *FSBuilder) WithSources(sources map[string]Finfo) *FSBuilder {
+	b.sources = sources
+	return b
 }
 
 func (b *FSBuilder) Build() (*fsNode, error) {
@@ -92,17 +119,18 @@ func (b *FSBuilder) Build() (*fsNode, error) {
 		return rootn, nil
 	}
 	for _, ln := range b.root.Links() {
-		fn, err := b.getNodeByLink(ln)
+		fn, err := b.getNodeByLink(ln, "")
 		if err != nil {
 			return nil, err
 		}
+		rootn.Size += fn.Size
 		rootn.Link = append(rootn.Link, fn)
 	}
 
 	return rootn, nil
 }
 
-func (b *FSBuilder) getNodeByLink(ln *ipld.Link) (fn fsNode, err error) {
+func (b *FSBuilder) getNodeByLink(ln *ipld.Link, parent string) (fn fsNode, err error) {
 	ctx := context.Background()
 	fn = fsNode{
 		Name: ln.Name,
@@ -125,19 +153,41 @@ func (b *FSBuilder) getNodeByLink(ln *ipld.Link) (fn fsNode, err error) {
 		log.Warnf("input dag is not a unixfs node: %s", err)
 		return
 	}
+	dagPath := path.Join(parent, ln.Name)
 	if !fsn.IsDir() {
+		fn.Size = fsn.FileSize()
+		if item, ok := b.sources[dagPath]; ok {
+			fn.Src = newFSSource(item)
+		}
 		return
 	}
+	fn.Size = 0
 	for _, ln := range nnd.Links() {
-		node, err := b.getNodeByLink(ln)
+		node, err := b.getNodeByLink(ln, dagPath)
 		if err != nil {
 			return node, err
 		}
+		fn.Size += node.Size
 		fn.Link = append(fn.Link, node)
 	}
 	return
 }
 
+func newFSSource(item Finfo) *fsSource {
+	src := &fsSource{
+		Path:         item.Path,
+		Size:         item.Info.Size(),
+		Length:       item.Info.Size(),
+		Checksum:     item.checksum,
+		FileChecksum: item.fileChecksum,
+	}
+	if item.SeekStart > 0 || item.SeekEnd > 0 {
+		src.Offset = item.SeekStart
+		src.Length = item.SeekEnd - item.SeekStart + 1
+	}
+	return src
+}
+
 func BuildIpldGraph(ctx context.Context,
 	fileList []Finfo,
 	graphName string,
@@ -147,13 +197,27 @@ func BuildIpldGraph(ctx context.Context,
 	defer func() {
 		log.Infof("BuildIpldGraph took: %v", time.Since(start))
 	}()
-	buf, payloadCid, fsDetail, err := buildIpldGraph(ctx, fileList, params.ParentPath, params.Parallel,
-		params.ExpectSliceSize, params.Ef, params.SkipFilename)
+	buf, payloadCid, fsDetail, index, err := buildIpldGraph(ctx, fileList, params.ParentPath, params.Parallel,
+		params.ExpectSliceSize, params.Ef, params.SkipFilename, params.hashes)
 	if err != nil {
 		// log.Fatal(err)
 		params.Cb.OnError(err)
 		return
 	}
+	index.GraphName = graphName
+	// the CAR holds more than its files, its piece is checked again
+	sectorSize := params.SectorSize
+	if sectorSize == 0 {
+		sectorSize = DefaultSectorSize
+	}
+	if err := checkPieceFits(int64(buf.Len()), sectorSize); err != nil {
+		params.Cb.OnError(fmt.Errorf("slice %s: %w", graphName, err))
+		return
+	}
+	if cb, ok := params.Cb.(SliceIndexCallback); ok {
+		cb.OnSliceIndexSuccess(buf, graphName, payloadCid, fsDetail, index)
+		return
+	}
 	params.Cb.OnSuccess(buf, graphName, payloadCid, fsDetail)
 }
 
@@ -164,16 +228,21 @@ func buildIpldGraph(ctx context.Context,
 	sliceSize int64,
 	ef *ExtraFile,
 	skipFilename bool,
-) (*Buffer, string, string, error) {
+	hashes *fileHashes,
+) (*Buffer, string, string, *SliceIndex, error) {
 	bs2 := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
 	dagServ := dag.NewDAGService(blockservice.New(bs2, offline.Exchange(bs2)))
 
 	cidBuilder, err := dag.PrefixForCidVersion(1)
 	if err != nil {
-		return nil, "", "", err
+		return nil, "", "", nil, err
 	}
 	fileNodeMap := make(map[string]*dag.ProtoNode)
+	// checksums of each item, the checksum and file checksum
+	checksums := make(map[string][2]string)
 	dirNodeMap := make(map[string]*dag.ProtoNode)
+	// source file of each file node, keyed by its path inside the graph
+	sources := make(map[string]Finfo)
 
 	var rootNode *dag.ProtoNode
 	rootNode = unixfs.EmptyDirNode()
@@ -201,7 +270,8 @@ func buildIpldGraph(ctx context.Context,
 				wg.Done()
 			}()
 			pchan <- struct{}{}
-			fileNode, err := BuildFileNode(item, dagServ, cidBuilder)
+			part, file := hashes.writers(item)
+			fileNode, err := buildFileNode(item, dagServ, cidBuilder, part, file)
 			if err != nil {
 				log.Warn(err)
 				return
@@ -211,8 +281,10 @@ func buildIpldGraph(ctx context.Context,
 				log.Warn("file node should be *dag.ProtoNode")
 				return
 			}
+			checksum, fileChecksum := hashes.done(item, part, file)
 			lock.Lock()
 			fileNodeMap[item.Path] = fn
+			checksums[item.Path] = [2]string{checksum, fileChecksum}
 			lock.Unlock()
 			// log.Infof("path: %s, file node: %s", item.Path, fileNode)
 		}(i, item)
@@ -248,6 +320,8 @@ func buildIpldGraph(ctx context.Context,
 		if !ok {
 			panic("unexpected, missing file node")
 		}
+		item.checksum, item.fileChecksum = checksums[item.Path][0], checksums[item.Path][1]
+		sources[path.Join(append(dirList, item.Name)...)] = item
 		if len(dirList) == 0 {
 			dirNodeMap[rootKey].AddNodeLink(item.Name, fileNode)
 			continue
@@ -290,7 +364,7 @@ func buildIpldGraph(ctx context.Context,
 			if isLinked(parentNode, dir) {
 				parentNode, err = parentNode.UpdateNodeLink(dir, dirNode)
 				if err != nil {
-					return nil, "", "", err
+					return nil, "", "", nil, err
 				}
 				dirNodeMap[parentKey] = parentNode
 			} else {
@@ -315,20 +389,14 @@ func buildIpldGraph(ctx context.Context,
 	sc := car.NewSelectiveCar(ctx, bs2, []car.Dag{{Root: rootNode.Cid(), Selector: selector}})
 	err = sc.Write(buf)
 	if err != nil {
-		return nil, "", "", err
+		return nil, "", "", nil, err
 	}
 	log.Infof("generate car file completed, time elapsed: %s", time.Since(genCarStartTime))
 
-	// fsBuilder := NewFSBuilder(rootNode, dagServ)
-	// _, err = fsBuilder.Build()
-	// if err != nil {
-	// 	return nil, "", "", err
-	// }
-	// fsNodeBytes, err := json.Marshal(fsNode)
-	// if err != nil {
-	// 	return nil, "", "", err
-	// }
-	// log.Info(dirNodeMap)
+	fsRoot, err := NewFSBuilder(rootNode, dagServ).WithSources(sources).Build()
+	if err != nil {
+		return nil, "", "", nil, err
+	}
 
 	var infos []SimplestFileInfo
 	for i, info := range sfis {
@@ -342,7 +410,7 @@ func buildIpldGraph(ctx context.Context,
 
 	fileInfo, err := json.Marshal(infos)
 	if err != nil {
-		return nil, "", "", err
+		return nil, "", "", nil, err
 	}
 	log.Info("++++++++++++ finished to build ipld +++++++++++++")
 
@@ -365,11 +433,15 @@ func buildIpldGraph(ctx context.Context,
 
 		fileInfo, err = json.Marshal(list)
 		if err != nil {
-			return nil, "", "", err
+			return nil, "", "", nil, err
 		}
 	}
 
-	return buf, rootNode.Cid().String(), string(fileInfo), nil
+	index := &SliceIndex{
+		PayloadCid: rootNode.Cid().String(),
+		Root:       fsRoot,
+	}
+	return buf, rootNode.Cid().String(), string(fileInfo), index, nil
 }
 
 func allSelector() ipldprime.Node {
@@ -449,11 +521,18 @@ func (fs *fileSlice) Read(p []byte) (n int, err error) {
 }
 
 func BuildFileNode(item Finfo, bufDs ipld.DAGService, cidBuilder cid.Builder) (node ipld.Node, err error) {
+	return buildFileNode(item, bufDs, cidBuilder, nil, nil)
+}
+
+// buildFileNode builds the file node of item like BuildFileNode, the content of item is also
+// written to the hashes that are not nil
+func buildFileNode(item Finfo, bufDs ipld.DAGService, cidBuilder cid.Builder, hashes ...hash.Hash) (node ipld.Node, err error) {
 	var r io.Reader
 	f, err := os.Open(item.Path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 	r = f
 
 	// read all data of item
@@ -465,6 +544,15 @@ func BuildFileNode(item Finfo, bufDs ipld.DAGService, cidBuilder cid.Builder) (n
 			fileSize: item.Info.Size(),
 		}
 	}
+	var ws []io.Writer
+	for _, h := range hashes {
+		if h != nil {
+			ws = append(ws, h)
+		}
+	}
+	if len(ws) > 0 {
+		r = io.TeeReader(r, io.MultiWriter(ws...))
+	}
 
 	params := ihelper.DagBuilderParams{
 		Maxlinks:   UnixfsLinksPerLevel,
@@ -611,8 +699,12 @@ func (nr NullReader) Read(b []byte) (int, error) {
 }
 
 func PadCar(w io.Writer, carSize int64) error {
-	pieceSize := padreader.PaddedSize(uint64(carSize))
-	if int64(pieceSize) == carSize {
+	return padCarTo(w, carSize, padreader.PaddedSize(uint64(carSize)))
+}
+
+// padCarTo writes the zeros padding a CAR file of carSize bytes to the piece size
+func padCarTo(w io.Writer, carSize int64, pieceSize abi.UnpaddedPieceSize) error {
+	if int64(pieceSize) <= carSize {
 		return nil
 	}
 	nr := io.LimitReader(NullReader{}, int64(pieceSize)-carSize)
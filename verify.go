@@ -0,0 +1,346 @@
+package graphsplit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	ipldcodec "github.com/ipld/go-ipld-prime/multicodec"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/multiformats/go-multihash"
+)
+
+// maxCarVerifyErrors is the number of errors listed for a CAR file, the rest are only counted
+const maxCarVerifyErrors = 100
+
+// VerifyParams configures Verify
+type VerifyParams struct {
+	CarPath string
+	// Manifest is checked for the payload size of every CAR file, by default the manifest chunk
+	// wrote next to the CAR files is used when there is one
+	Manifest string
+	Parallel int
+}
+
+// CarVerifyResult tells whether a CAR file passed Verify, and why it failed
+type CarVerifyResult struct {
+	Car    string `json:"car"`
+	Blocks int    `json:"blocks"`
+	// PayloadSize is the size of the CARv1 payload, without the CARv2 wrapper or the piece padding
+	PayloadSize int64    `json:"payload_size"`
+	Errors      []string `json:"errors,omitempty"`
+	// ErrorCount counts all the errors, only the first ones are listed in Errors
+	ErrorCount int `json:"error_count,omitempty"`
+}
+
+func (r *CarVerifyResult) OK() bool {
+	return r.ErrorCount == 0
+}
+
+func (r *CarVerifyResult) fail(format string, args ...any) {
+	r.ErrorCount++
+	if r.ErrorCount <= maxCarVerifyErrors {
+		r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// Verify checks the CAR files in CarPath without restoring them: every block hashes to its CID,
+// the DAG of the roots has no missing block, there is no duplicate or orphan block, no block
+// holds more than 1 MiB of data, and the payload size matches the manifest. The results are in
+// the order of the CAR files.
+func Verify(ctx context.Context, params *VerifyParams) ([]*CarVerifyResult, error) {
+	if params.Parallel <= 0 {
+		return nil, fmt.Errorf("parallel has to be greater than 0")
+	}
+	var cars []string
+	err := filepath.Walk(params.CarPath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && !isCarDirSidecar(fi.Name()) {
+			cars = append(cars, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(cars) == 0 {
+		return nil, fmt.Errorf("no CAR file found in %s", params.CarPath)
+	}
+
+	// the manifest entries of every CAR directory, by payload CID
+	manifests := make(map[string]map[string]*ManifestEntry)
+	manifestOf := func(carPath string) (map[string]*ManifestEntry, error) {
+		manifestPath := params.Manifest
+		if manifestPath == "" {
+			if manifestPath = FindManifest(filepath.Dir(carPath)); manifestPath == "" {
+				return nil, nil
+			}
+		}
+		if m, ok := manifests[manifestPath]; ok {
+			return m, nil
+		}
+		entries, err := ReadManifest(manifestPath)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]*ManifestEntry, len(entries))
+		for _, e := range entries {
+			m[e.PayloadCid] = e
+		}
+		manifests[manifestPath] = m
+		return m, nil
+	}
+	carManifests := make([]map[string]*ManifestEntry, len(cars))
+	for i, carPath := range cars {
+		if carManifests[i], err = manifestOf(carPath); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]*CarVerifyResult, len(cars))
+	var wg sync.WaitGroup
+	idxCh := make(chan int)
+	for i := 0; i < params.Parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idxCh {
+				results[i] = verifyCar(cars[i], carManifests[i])
+				if results[i].OK() {
+					log.Infof("%s passed, %d blocks", cars[i], results[i].Blocks)
+				} else {
+					log.Errorf("%s failed with %d errors", cars[i], results[i].ErrorCount)
+				}
+			}
+		}()
+	}
+	for i := range cars {
+		if ctx.Err() != nil {
+			break
+		}
+		idxCh <- i
+	}
+	close(idxCh)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func verifyCar(carPath string, manifest map[string]*ManifestEntry) *CarVerifyResult {
+	res := &CarVerifyResult{Car: carPath}
+	f, err := os.Open(carPath)
+	if err != nil {
+		res.fail("%s", err)
+		return res
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		res.fail("%s", err)
+		return res
+	}
+	payload, _, _, err := carPayload(f, fi.Size())
+	if err != nil {
+		res.fail("%s", err)
+		return res
+	}
+	cr := &countingReader{r: payload}
+	br := bufio.NewReader(cr)
+	offset := func() int64 { return cr.n - int64(br.Buffered()) }
+	header, err := car.ReadHeader(br)
+	if err != nil {
+		res.fail("invalid CAR header: %s", err)
+		return res
+	}
+
+	links := make(map[cid.Cid][]cid.Cid)
+	var order []cid.Cid
+	for {
+		next, err := br.Peek(1)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			res.fail("failed to read block at offset %d: %s", offset(), err)
+			break
+		}
+		// a padded piece file ends with zeros after the CAR payload
+		if next[0] == 0 {
+			res.PayloadSize = offset()
+			if n, err := checkZeroPadding(br); err != nil {
+				res.fail("failed to read the padding: %s", err)
+			} else if n >= 0 {
+				res.fail("non-zero byte in the padding at offset %d", res.PayloadSize+n)
+			}
+			break
+		}
+		blockOffset := offset()
+		c, data, err := carutil.ReadNode(br)
+		if err != nil {
+			res.fail("malformed block at offset %d: %s", blockOffset, err)
+			break
+		}
+		res.Blocks++
+		if _, dup := links[c]; dup {
+			res.fail("duplicate block %s at offset %d", c, blockOffset)
+			continue
+		}
+		if sum, err := c.Prefix().Sum(data); err != nil {
+			res.fail("block %s: %s", c, err)
+		} else if !sum.Equals(c) {
+			res.fail("block %s: data hashes to %s", c, sum)
+		}
+		ls, size, err := blockLinks(c, data)
+		if err != nil {
+			res.fail("block %s: %s", c, err)
+		}
+		if size > chunker.ChunkSizeLimit {
+			res.fail("block %s holds %d bytes of data, more than 1 MiB", c, size)
+		}
+		links[c] = ls
+		order = append(order, c)
+	}
+	if res.PayloadSize == 0 {
+		res.PayloadSize = offset()
+	}
+
+	// every block is reachable from the roots, and every block they link to is in the CAR
+	reached := make(map[cid.Cid]bool)
+	type edge struct{ from, to cid.Cid }
+	queue := make([]edge, 0, len(header.Roots))
+	for _, root := range header.Roots {
+		queue = append(queue, edge{to: root})
+	}
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+		if reached[e.to] {
+			continue
+		}
+		reached[e.to] = true
+		ls, ok := links[e.to]
+		switch {
+		case ok:
+		case e.to.Prefix().MhType == multihash.IDENTITY:
+			// the data of an identity CID is inlined, there is no block
+		case !e.from.Defined():
+			res.fail("root %s is missing", e.to)
+		default:
+			res.fail("block %s linked from %s is missing", e.to, e.from)
+		}
+		for _, l := range ls {
+			queue = append(queue, edge{from: e.to, to: l})
+		}
+	}
+	for _, c := range order {
+		if !reached[c] {
+			res.fail("orphan block %s is not linked from the roots", c)
+		}
+	}
+
+	if manifest != nil {
+		if len(header.Roots) != 1 {
+			res.fail("CAR has %d roots, cannot find it in the manifest", len(header.Roots))
+		} else if e, ok := manifest[header.Roots[0].String()]; !ok {
+			res.fail("payload %s is not in the manifest", header.Roots[0])
+		} else if e.HasCommP && e.PayloadSize != res.PayloadSize {
+			res.fail("payload size is %d, the manifest records %d", res.PayloadSize, e.PayloadSize)
+		}
+	}
+	return res
+}
+
+// checkZeroPadding reads r to the end, it returns the offset of the first byte that is not zero, or -1
+func checkZeroPadding(r io.Reader) (int64, error) {
+	buf := make([]byte, 32<<10)
+	var off int64
+	for {
+		n, err := r.Read(buf)
+		for i, b := range buf[:n] {
+			if b != 0 {
+				return off + int64(i), nil
+			}
+		}
+		off += int64(n)
+		if err == io.EOF {
+			return -1, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// blockLinks decodes the links of a block, along with the size of the data it holds. As go-ipfs-chunker
+// puts it, the data of a UnixFS leaf is counted without its wrapping overhead.
+func blockLinks(c cid.Cid, data []byte) ([]cid.Cid, int, error) {
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		return nil, len(data), nil
+	case cid.DagProtobuf:
+		pn, err := merkledag.DecodeProtobuf(data)
+		if err != nil {
+			return nil, len(data), fmt.Errorf("invalid dag-pb: %w", err)
+		}
+		if len(pn.Links()) == 0 {
+			if fsn, err := unixfs.FSNodeFromBytes(pn.Data()); err == nil {
+				return nil, len(fsn.Data()), nil
+			}
+		}
+		ls := make([]cid.Cid, 0, len(pn.Links()))
+		for _, l := range pn.Links() {
+			ls = append(ls, l.Cid)
+		}
+		return ls, len(data), nil
+	}
+	dec, err := ipldcodec.LookupDecoder(c.Prefix().Codec)
+	if err != nil {
+		return nil, len(data), fmt.Errorf("cannot read the links of codec 0x%x", c.Prefix().Codec)
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dec(nb, bytes.NewReader(data)); err != nil {
+		return nil, len(data), fmt.Errorf("failed to decode: %w", err)
+	}
+	lnks, err := traversal.SelectLinks(nb.Build())
+	if err != nil {
+		return nil, len(data), err
+	}
+	ls := make([]cid.Cid, 0, len(lnks))
+	for _, l := range lnks {
+		if cl, ok := l.(cidlink.Link); ok {
+			ls = append(ls, cl.Cid)
+		}
+	}
+	return ls, len(data), nil
+}
@@ -0,0 +1,115 @@
+package graphsplit
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+func TestVerify(t *testing.T) {
+	carDir := t.TempDir()
+	chunkTestData(t, carDir, 64<<10)
+	ctx := context.Background()
+
+	results, err := Verify(ctx, &VerifyParams{CarPath: carDir, Parallel: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if !res.OK() || res.Blocks == 0 {
+			t.Fatalf("%s: unexpected result %+v", res.Car, res)
+		}
+	}
+
+	// rewrite the CAR holding y.txt with a corrupted block, a missing block, a duplicate block
+	// and an orphan block larger than 1 MiB
+	carPath := leafCars(t, carDir)["y.txt"]
+	f, err := os.Open(carPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br, err := carv2.NewBlockReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blks []blocks.Block
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blks = append(blks, blk)
+	}
+	f.Close()
+	if len(blks) < 3 {
+		t.Fatalf("expect at least 3 blocks in %s", carPath)
+	}
+	corrupted := append([]byte{}, blks[1].RawData()...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	missing := blks[2].Cid()
+	orphan := blocks.NewBlock(make([]byte, 1<<20+1))
+
+	out, err := os.Create(carPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := car.WriteHeader(&car.CarHeader{Roots: br.Roots, Version: 1}, out); err != nil {
+		t.Fatal(err)
+	}
+	write := func(c cid.Cid, data []byte) {
+		if err := carutil.LdWrite(out, c.Bytes(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(blks[0].Cid(), blks[0].RawData())
+	write(blks[1].Cid(), corrupted)
+	for _, blk := range blks[3:] {
+		write(blk.Cid(), blk.RawData())
+	}
+	write(blks[0].Cid(), blks[0].RawData())
+	write(orphan.Cid(), orphan.RawData())
+	out.Close()
+
+	// a manifest recording another payload size
+	manifestPath := filepath.Join(t.TempDir(), ManifestFileName(ManifestFormatJSONL))
+	err = AppendManifest(filepath.Dir(manifestPath), ManifestFormatJSONL, &ManifestEntry{
+		PayloadCid: br.Roots[0].String(), PieceCid: "baga", PayloadSize: 1, Files: []ManifestFile{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err = Verify(ctx, &VerifyParams{CarPath: carPath, Manifest: manifestPath, Parallel: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := results[0]
+	if res.OK() {
+		t.Fatal("expect the rewritten CAR to fail")
+	}
+	errs := strings.Join(res.Errors, "\n")
+	for _, expected := range []string{
+		"block " + blks[1].Cid().String() + ": data hashes to",
+		"block " + missing.String() + " linked from",
+		"duplicate block " + blks[0].Cid().String(),
+		"orphan block " + orphan.Cid().String(),
+		"block " + orphan.Cid().String() + " holds 1048577 bytes of data",
+		"the manifest records 1",
+	} {
+		if !strings.Contains(errs, expected) {
+			t.Errorf("missing error %q in\n%s", expected, errs)
+		}
+	}
+}